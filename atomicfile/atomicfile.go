@@ -0,0 +1,71 @@
+package atomicfile
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write writes a file in a temporary file next to it, and only renames it to its real name once complete, so that a
+// crash never leaves a half written file behind. The fill function writes the content, its errors are sent back as
+// they are.
+func Write(filename string, fill func(w io.Writer) error) (err error) {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// whatever happens, the temporary file must not stay there
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(tmpFile)
+	if err = fill(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// WriteDir prepares a directory under a temporary name next to it, and only renames it to its real name once
+// complete. A directory already there with the same name is replaced. The fill function writes the files in the
+// temporary directory it is given.
+func WriteDir(dirname string, fill func(dir string) error) (err error) {
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dirname), "."+filepath.Base(dirname)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// whatever happens, the temporary directory must not stay there
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmpDir)
+		}
+	}()
+
+	if err = fill(tmpDir); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+	if err = os.RemoveAll(dirname); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dirname)
+}
@@ -0,0 +1,324 @@
+package chapterid
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/francoiscolombo/gomangareaderdl/natural"
+)
+
+// ID identifies a chapter of a manga: a number, with decimals for the chapters published between two others
+// (10.5), or a label for the specials (extra, oneshot...). An ID ending with "+" means "just after" the chapter, it is
+// used in the history to remember where to restart after a decimal or special chapter.
+// The numbers are ordered by value, and come before the labels, which are in natural order
+type ID string
+
+// after is the suffix of the IDs meaning "just after" the chapter
+const after = "+"
+
+/*
+Parse read a chapter identifier as typed by a user or found on a site: the numbers lose their leading zeros but keep
+their decimals as they are, since 10.10 is not 10.1 (010.50 is 10.50), and the labels are in lowercase, with dashes
+instead of spaces. The chapters end up in the file names, so a label must have a letter or a digit, and can't start
+with a dot or a dash. A label can't be a number written otherwise, like 1e5
+*/
+func Parse(value string) (ID, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	base := strings.TrimSuffix(value, after)
+	suffix := value[len(base):]
+	if base == "" {
+		return "", fmt.Errorf("empty chapter")
+	}
+	if isNumber(base) {
+		return ID(normalize(base) + suffix), nil
+	}
+	base = strings.Join(strings.Fields(base), "-")
+	for _, c := range base {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", fmt.Errorf("invalid chapter <%s>", value)
+		}
+	}
+	if strings.HasPrefix(base, ".") || strings.HasPrefix(base, "-") || !strings.ContainsAny(base, "abcdefghijklmnopqrstuvwxyz0123456789") {
+		return "", fmt.Errorf("invalid chapter <%s>", value)
+	}
+	if _, err := strconv.ParseFloat(base, 64); err == nil && base[0] >= '0' && base[0] <= '9' {
+		return "", fmt.Errorf("invalid chapter <%s>", value)
+	}
+	return ID(base + suffix), nil
+}
+
+/*
+Validate check that the chapter is one Parse would send, before it is used in a file name
+*/
+func (id ID) Validate() error {
+	if parsed, err := Parse(string(id)); err != nil || parsed != id {
+		return fmt.Errorf("invalid chapter <%s>", string(id))
+	}
+	return nil
+}
+
+/*
+FromInt send the identifier of a chapter with a whole number
+*/
+func FromInt(number int) ID {
+	return ID(strconv.Itoa(number))
+}
+
+// isNumber check if a value is a number, with or without decimals
+func isNumber(value string) bool {
+	parts := strings.SplitN(value, ".", 2)
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// normalize remove the zeros before a number, the decimals are part of the chapter and left as they are
+func normalize(value string) string {
+	parts := strings.SplitN(value, ".", 2)
+	integer := strings.TrimLeft(parts[0], "0")
+	if integer == "" {
+		integer = "0"
+	}
+	if len(parts) == 2 {
+		return integer + "." + parts[1]
+	}
+	return integer
+}
+
+/*
+String send the chapter as it is written in the history
+*/
+func (id ID) String() string {
+	return string(id)
+}
+
+// base send the chapter without the "just after" suffix
+func (id ID) base() string {
+	return strings.TrimSuffix(string(id), after)
+}
+
+/*
+IsNumber tells if the chapter is a number, and not a special
+*/
+func (id ID) IsNumber() bool {
+	return isNumber(id.base())
+}
+
+/*
+Int send the number of the chapter, only for the chapters with a whole number
+*/
+func (id ID) Int() (int, bool) {
+	if strings.HasSuffix(string(id), after) {
+		return 0, false
+	}
+	number, err := strconv.Atoi(string(id))
+	return number, err == nil
+}
+
+/*
+Less tells if the chapter comes before another one
+*/
+func (id ID) Less(other ID) bool {
+	a, b := id.base(), other.base()
+	aNumber, bNumber := isNumber(a), isNumber(b)
+	switch {
+	case aNumber && !bNumber:
+		return true
+	case !aNumber && bNumber:
+		return false
+	case aNumber && bNumber:
+		if c := compareNumbers(a, b); c != 0 {
+			return c < 0
+		}
+	case a != b:
+		return natural.Less(a, b)
+	}
+	// the same chapter, "just after" it comes after it
+	return !strings.HasSuffix(string(id), after) && strings.HasSuffix(string(other), after)
+}
+
+// compareNumbers compare two normalized numbers without converting them, so that any precision works
+func compareNumbers(a, b string) int {
+	aParts, bParts := strings.SplitN(a, ".", 2), strings.SplitN(b, ".", 2)
+	if len(aParts[0]) != len(bParts[0]) {
+		if len(aParts[0]) < len(bParts[0]) {
+			return -1
+		}
+		return 1
+	}
+	if c := strings.Compare(aParts[0], bParts[0]); c != 0 {
+		return c
+	}
+	// the decimals compare digit by digit, which is the order of their value: .10 is before .5, and .50 comes just
+	// after .5 when they have the same value
+	aDecimals, bDecimals := "", ""
+	if len(aParts) == 2 {
+		aDecimals = aParts[1]
+	}
+	if len(bParts) == 2 {
+		bDecimals = bParts[1]
+	}
+	return strings.Compare(aDecimals, bDecimals)
+}
+
+/*
+Next send the chapter from which to restart once this one is downloaded: the next number for a whole number, and
+"just after" it for the others. A decimal chapter published after the next number is then behind the history, only
+the gaps command finds it
+*/
+func (id ID) Next() ID {
+	if number, ok := id.Int(); ok {
+		return FromInt(number + 1)
+	}
+	return ID(id.base() + after)
+}
+
+/*
+Padded send the chapter as it is written in the file names, the whole part of the numbers padded with zeros so that
+the files are listed in order: 007, 010.5, extra
+*/
+func (id ID) Padded() string {
+	base := id.base()
+	if !isNumber(base) {
+		return base
+	}
+	parts := strings.SplitN(base, ".", 2)
+	padded := parts[0]
+	if len(padded) < 3 {
+		padded = strings.Repeat("0", 3-len(padded)) + padded
+	}
+	if len(parts) == 2 {
+		padded += "." + parts[1]
+	}
+	return padded
+}
+
+/*
+Sort sort the chapters in reading order
+*/
+func Sort(ids []ID) {
+	sort.SliceStable(ids, func(i, j int) bool {
+		return ids[i].Less(ids[j])
+	})
+}
+
+/*
+MarshalJSON write the chapters with a whole number as numbers, like the history always did
+*/
+func (id ID) MarshalJSON() ([]byte, error) {
+	if number, ok := id.Int(); ok {
+		return json.Marshal(number)
+	}
+	return json.Marshal(string(id))
+}
+
+/*
+UnmarshalJSON read a chapter written as a number or as a string
+*/
+func (id *ID) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var number json.Number
+		if err = json.Unmarshal(data, &number); err != nil {
+			return err
+		}
+		value = number.String()
+	}
+	parsed, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// Selection is a list of chapters and ranges of chapters, like 5,7,9 or 100-120
+type Selection []Range
+
+// Range is a range of chapters, both included. A chapter given alone is a range from and to it, bare tells it was
+// not written as a range
+type Range struct {
+	From ID
+	To   ID
+	bare bool
+}
+
+/*
+ParseSelection read a list of chapters and ranges separated by commas. A range is two numbers separated by a dash,
+so that the specials with a dash in their label (extra-2) are still single chapters
+*/
+func ParseSelection(value string) (Selection, error) {
+	var selection Selection
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		bounds := strings.SplitN(item, "-", 2)
+		if len(bounds) == 2 {
+			bounds[0], bounds[1] = strings.TrimSpace(bounds[0]), strings.TrimSpace(bounds[1])
+		}
+		// a range with a missing bound is a typo, not a label
+		if len(bounds) == 2 && (bounds[0] == "" && isNumber(bounds[1]) || bounds[1] == "" && isNumber(bounds[0])) {
+			return nil, fmt.Errorf("invalid range <%s>, both chapters are needed", item)
+		}
+		if len(bounds) == 2 && isNumber(bounds[0]) && isNumber(bounds[1]) {
+			from, _ := Parse(bounds[0])
+			to, _ := Parse(bounds[1])
+			if to.Less(from) {
+				return nil, fmt.Errorf("invalid range <%s>, chapter %s is after chapter %s", item, from, to)
+			}
+			selection = append(selection, Range{From: from, To: to})
+			continue
+		}
+		chapter, err := Parse(item)
+		if err != nil {
+			return nil, err
+		}
+		selection = append(selection, Range{From: chapter, To: chapter, bare: true})
+	}
+	return selection, nil
+}
+
+/*
+Single send the chapter when the selection is only one chapter given alone, a range like 5-5 is not
+*/
+func (s Selection) Single() (ID, bool) {
+	if len(s) == 1 && s[0].bare {
+		return s[0].From, true
+	}
+	return "", false
+}
+
+/*
+First send the first chapter of the selection
+*/
+func (s Selection) First() ID {
+	var first ID
+	for i, r := range s {
+		if i == 0 || r.From.Less(first) {
+			first = r.From
+		}
+	}
+	return first
+}
+
+/*
+Contains check if a chapter is in the selection
+*/
+func (s Selection) Contains(id ID) bool {
+	for _, r := range s {
+		if !id.Less(r.From) && !r.To.Less(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package chapterid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ID
+		invalid bool
+	}{
+		{value: "10", want: "10"},
+		{value: "010", want: "10"},
+		{value: "10.5", want: "10.5"},
+		{value: "10.5+", want: "10.5+"},
+		{value: "010.50", want: "10.50"},
+		{value: "10.10", want: "10.10"},
+		{value: "Extra", want: "extra"},
+		{value: "side story", want: "side-story"},
+		{value: "extra-2", want: "extra-2"},
+		{value: "5-extra", want: "5-extra"},
+		{value: "", invalid: true},
+		{value: "-5", invalid: true},
+		{value: "-extra", invalid: true},
+		{value: ".hidden", invalid: true},
+		{value: "1e5", invalid: true},
+		{value: "1.5e3", invalid: true},
+		{value: "a/b", invalid: true},
+		{value: "--", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := Parse(tt.value)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Selection
+		invalid bool
+	}{
+		{value: "100", want: Selection{{From: "100", To: "100", bare: true}}},
+		{value: "100-120", want: Selection{{From: "100", To: "120"}}},
+		{value: "5, 7,9", want: Selection{{From: "5", To: "5", bare: true}, {From: "7", To: "7", bare: true}, {From: "9", To: "9", bare: true}}},
+		{value: "10.5 - 12", want: Selection{{From: "10.5", To: "12"}}},
+		{value: "extra-2", want: Selection{{From: "extra-2", To: "extra-2", bare: true}}},
+		{value: "120-100", invalid: true},
+		{value: "5-", invalid: true},
+		{value: "-5", invalid: true},
+		{value: "100-", invalid: true},
+		{value: "5,,7", invalid: true},
+		{value: "1e5", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSelection(tt.value)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,37 +1,68 @@
 package commands
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+	"github.com/francoiscolombo/gomangareaderdl/createcbz"
 	"github.com/francoiscolombo/gomangareaderdl/fetch"
+	"github.com/francoiscolombo/gomangareaderdl/imaging"
+	"github.com/francoiscolombo/gomangareaderdl/output"
 	"github.com/francoiscolombo/gomangareaderdl/settings"
 )
 
 /*
 ProcessConfigCommand process the config command, and update the default configuration regarding the parameters passed
 */
-func ProcessConfigCommand(cfg *settings.Settings, defaultOutputPath string, defaultProvider string) {
+func ProcessConfigCommand(cfg *settings.Settings, defaultOutputPath string, defaultProvider string, defaultFormat string, defaultProfile string) {
 	if defaultOutputPath == "???" {
 		defaultOutputPath = cfg.Config.OutputPath
 	}
 	if defaultProvider == "???" {
 		defaultProvider = cfg.Config.Provider
 	}
+	if defaultFormat == "???" {
+		defaultFormat = cfg.Config.Format
+	}
+	if defaultProfile == "???" {
+		defaultProfile = cfg.Config.Profile
+	}
+	if _, err := output.GetFormat(defaultFormat, output.Options{}); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if _, err := settings.GetProfile(*cfg, defaultProfile); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	fmt.Println("- <Config> command selected, with the following parameters:")
 	fmt.Printf("  > Default output path to set : '%s'\n", defaultOutputPath)
-	fmt.Printf("  > Default provider is set to <%s>", defaultProvider)
-	if (defaultOutputPath != cfg.Config.OutputPath) || (defaultProvider != cfg.Config.Provider) {
+	fmt.Printf("  > Default provider is set to <%s>\n", defaultProvider)
+	fmt.Printf("  > Default format is set to %s\n", defaultFormat)
+	fmt.Printf("  > Default profile is set to %s", defaultProfile)
+	if (defaultOutputPath != cfg.Config.OutputPath) || (defaultProvider != cfg.Config.Provider) || (defaultFormat != cfg.Config.Format) || (defaultProfile != cfg.Config.Profile) {
 		(*cfg).Config.OutputPath = defaultOutputPath
 		(*cfg).Config.Provider = defaultProvider
+		(*cfg).Config.Format = defaultFormat
+		(*cfg).Config.Profile = defaultProfile
 		settings.WriteSettings((*cfg))
 	}
 }
 
 /*
-ProcessFetchCommand allows to download a manga, from the first given chapter to the last available one.
+ProcessFetchCommand allows to download a manga, every chapter of the list given by the provider from the first
+given chapter to the last available one, or only the chapters of a list of chapters and ranges. The download can
+stop at a chapter (until) or after a number of chapters (maxChapters). When the context is cancelled, the chapter in
+progress is dropped and the history keeps the last complete one.
 */
-func ProcessFetchCommand(cfg *settings.Settings, manga string, chapter int, provider string, path string, force bool, silent bool) {
+func ProcessFetchCommand(ctx context.Context, cfg *settings.Settings, manga string, chapterFlag string, until string, maxChapters int, provider string, path string, force bool, silent bool, jobs int, format string, profile string) {
 	if manga == "???" {
 		fmt.Println("parameter --manga is mandatory...")
 		os.Exit(1)
@@ -42,65 +73,664 @@ func ProcessFetchCommand(cfg *settings.Settings, manga string, chapter int, prov
 	if provider == "???" {
 		provider = cfg.Config.Provider
 	}
-	if chapter < 0 {
-		chapter = settings.SearchLastChapter((*cfg), manga)
+	adapter, err := fetch.GetProvider(provider)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if format == "???" {
+		format = cfg.Config.Format
+	}
+	writer, err := output.GetFormat(format, output.Options{
+		Compression:      cfg.Config.Compression,
+		DisableComicInfo: cfg.Config.DisableComicInfo,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if profile == "???" {
+		profile = cfg.Config.Profile
+	}
+	deviceProfile, err := settings.GetProfile(*cfg, profile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	processing := processingOptions(cfg, manga, deviceProfile)
+	chapter := settings.SearchLastChapter((*cfg), manga)
+	var selection chapterid.Selection
+	if chapterFlag != "???" {
+		if selection, err = chapterid.ParseSelection(chapterFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		chapter = selection.First()
+		// a single chapter is where to start from, not the only chapter to download
+		if _, ok := selection.Single(); ok {
+			selection = nil
+		}
 	}
+	var lastToFetch chapterid.ID
+	if until != "???" {
+		if lastToFetch, err = chapterid.Parse(until); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if jobs <= 0 {
+		jobs = cfg.Config.Jobs
+	}
+	fetch.SetJobs(jobs, cfg.Config.HostJobs)
 	fmt.Println("- <Fetch> command selected, with the following parameters:")
 	fmt.Printf("  > Manga title to fetch : '%s'\n", manga)
 	fmt.Printf("  > Download from provider <%s>\n", provider)
-	fmt.Printf("  > Start to fetch from chapter %d\n", chapter)
-	fmt.Printf("  > Download to output path '%s'\n", path)
-	if force {
-		fmt.Printf("  > We are restarting the download from chapter %d\n", chapter)
+	if selection != nil {
+		fmt.Printf("  > Only fetch the chapters %s\n", chapterFlag)
 	} else {
-		lastChapter := settings.SearchLastChapter((*cfg), manga)
-		if lastChapter > chapter {
-			chapter = lastChapter
+		fmt.Printf("  > Start to fetch from chapter %s\n", chapter)
+	}
+	if lastToFetch != "" {
+		fmt.Printf("  > Stop after chapter %s\n", lastToFetch)
+	}
+	if maxChapters > 0 {
+		fmt.Printf("  > Fetch %d chapters at most\n", maxChapters)
+	}
+	fmt.Printf("  > Download to output path '%s'\n", path)
+	fmt.Printf("  > Write the chapters as %s\n", format)
+	fmt.Printf("  > Prepare the pages with the profile %s\n", profile)
+	if processing.Trim {
+		fmt.Println("  > Trim the borders of the pages")
+	}
+	if spreadName(processing.Spread) != imaging.SpreadKeep {
+		fmt.Printf("  > Landscape pages : %s\n", processing.Spread)
+	}
+	if len(processing.Blocklist) > 0 {
+		fmt.Printf("  > Drop the pages looking like one of the %d pages of the blocklist\n", len(processing.Blocklist))
+	}
+	// the chapters of a selection are downloaded even if the history is already past them
+	if selection == nil {
+		if force {
+			fmt.Printf("  > We are restarting the download from chapter %s\n", chapter)
+		} else {
+			lastChapter := settings.SearchLastChapter((*cfg), manga)
+			if chapter.Less(lastChapter) {
+				chapter = lastChapter
+			}
+			fmt.Printf("  > We are now searching for new chapter %s\n", chapter)
 		}
-		fmt.Printf("  > We are now searching for new chapter %d\n", chapter)
 	}
 	if silent {
 		fmt.Printf("  > Download of %s will be done silently (no progress bar)\n", manga)
 	}
-	if fetch.NextChapter(provider, manga, chapter) == false {
-		fmt.Printf("chapter %d for %s is not yet available to download, sorry.", chapter, manga)
+	fmt.Printf("  > Download %d pages at the same time, %d per host at most\n", jobs, cfg.Config.HostJobs)
+	metadata := readMetadata(ctx, adapter, manga, writer)
+	available, err := adapter.Chapters(ctx, manga)
+	if err != nil {
+		fmt.Printf("unable to read the chapter list of %s: %s\n", manga, err)
+		os.Exit(1)
+	}
+	// the chapters already downloaded are not downloaded again, unless forced
+	var skipped map[chapterid.ID]bool
+	if !force {
+		skipped = recordedChapters(cfg, manga)
+	}
+	chapters := selectChapters(available, chapter, selection, lastToFetch, maxChapters, skipped)
+	if len(chapters) == 0 {
+		fmt.Printf("chapter %s for %s is not yet available to download, sorry.", chapter, manga)
 	} else {
-		for {
-			if fetch.NextChapter(provider, manga, chapter) == true {
-				chapter = fetch.Manga(provider, manga, chapter, path, !silent)
-			} else {
-				break
-			}
+		fmt.Printf("  > %d chapters to download: %v\n", len(chapters), chapters)
+		downloadChapters(ctx, cfg, adapter, manga, provider, chapters, path, silent, writer, metadata, processing)
+		// a selection restarts from the history, not from its first chapter
+		if selection != nil {
+			chapter = settings.SearchLastChapter((*cfg), manga)
+		}
+		*cfg = settings.UpdateHistory(*cfg, manga, nextChapter(available, chapter, recordedChapters(cfg, manga)), provider)
+	}
+}
+
+/*
+readMetadata read the description of a manga from the site, only when the format writes it. Only the title is known
+otherwise
+*/
+func readMetadata(ctx context.Context, adapter fetch.Provider, manga string, writer output.Format) fetch.Metadata {
+	if !writer.UsesMetadata() {
+		return fetch.Metadata{Title: manga}
+	}
+	metadata, err := adapter.Metadata(ctx, manga)
+	if err != nil {
+		fmt.Printf("  > Unable to read the metadata of %s, the archives will only have the basic ones: %s\n", manga, err)
+		return fetch.Metadata{Title: manga}
+	}
+	return metadata
+}
+
+/*
+processingOptions send the steps to run on the pages of a manga: the device profile, the processing of the manga and
+the blocklist
+*/
+func processingOptions(cfg *settings.Settings, manga string, deviceProfile imaging.Profile) imaging.Options {
+	mangaProcessing := settings.SearchProcessing(*cfg, manga)
+	return imaging.Options{
+		Profile:   deviceProfile,
+		Trim:      mangaProcessing.Trim,
+		Spread:    mangaProcessing.Spread,
+		Blocklist: settings.ReadBlocklist(*cfg),
+	}
+}
+
+/*
+downloadChapters download the chapters of a manga one after the other and record them in the history, a chapter
+which fails is reported and skipped. The download stops when the context is cancelled
+*/
+func downloadChapters(ctx context.Context, cfg *settings.Settings, adapter fetch.Provider, manga, provider string, chapters []chapterid.ID, path string, silent bool, writer output.Format, metadata fetch.Metadata, processing imaging.Options) {
+	var failed []chapterid.ID
+	for _, chapter := range chapters {
+		_, archive, err := fetch.Manga(ctx, adapter, manga, chapter, path, !silent, writer, metadata, processing)
+		if err != nil && ctx.Err() != nil {
+			fmt.Printf("\ndownload of chapter %s for %s interrupted, it will be resumed next time\n", chapter, manga)
+			break
+		}
+		if err != nil {
+			// don't stop the whole batch for one chapter, report it and continue with the next one
+			fmt.Printf("\nchapter %s for %s is skipped: %s\n", chapter, manga, err)
+			failed = append(failed, chapter)
+			continue
+		}
+		*cfg = settings.RecordChapter(*cfg, manga, chapter, provider, archive)
+	}
+	if len(failed) > 0 {
+		fmt.Printf("the following chapters for %s could not be downloaded: %v, they will be downloaded again next time\n", manga, failed)
+	}
+}
+
+/*
+recordedChapters send the chapters of a manga recorded in the history
+*/
+func recordedChapters(cfg *settings.Settings, manga string) map[chapterid.ID]bool {
+	recorded := make(map[chapterid.ID]bool)
+	for _, chapter := range settings.SearchChapters(*cfg, manga) {
+		recorded[chapter.Chapter] = true
+	}
+	return recorded
+}
+
+/*
+nextChapter send the chapter to download next: from the given one, the history only moves over the chapters
+recorded without a hole, so that a chapter which failed, was interrupted or was left out is downloaded next time
+*/
+func nextChapter(available []chapterid.ID, from chapterid.ID, done map[chapterid.ID]bool) chapterid.ID {
+	next := from
+	for _, chapter := range available {
+		if chapter.Less(from) {
+			continue
+		}
+		if !done[chapter] {
+			break
+		}
+		next = chapter.Next()
+	}
+	return next
+}
+
+/*
+ProcessGapsCommand search the chapters missing for the mangas of the history, or only for one of them: the chapters
+of the provider list before the next chapter to download which are neither on disk nor recorded in the history.
+With repair, the missing chapters are downloaded, and the next chapter to download stays the same
+*/
+func ProcessGapsCommand(ctx context.Context, cfg *settings.Settings, manga string, path string, repair bool, silent bool) {
+	if path == "???" {
+		path = cfg.Config.OutputPath
+	}
+	writer, err := output.GetFormat(cfg.Config.Format, output.Options{
+		Compression:      cfg.Config.Compression,
+		DisableComicInfo: cfg.Config.DisableComicInfo,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	deviceProfile, err := settings.GetProfile(*cfg, cfg.Config.Profile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("- <Gaps> command selected, with the following parameters:")
+	if manga != "???" {
+		fmt.Printf("  > Manga title to check : '%s'\n", manga)
+	} else {
+		fmt.Println("  > Check every manga of the history")
+	}
+	fmt.Printf("  > Search the chapters in output path '%s'\n", path)
+	if repair {
+		fmt.Printf("  > Download the missing chapters as %s\n", cfg.Config.Format)
+		fetch.SetJobs(cfg.Config.Jobs, cfg.Config.HostJobs)
+	}
+	found := false
+	for _, title := range cfg.History.Titles {
+		if manga != "???" && title.Title != manga {
+			continue
+		}
+		found = true
+		adapter, err := fetch.GetProvider(title.Provider)
+		if err != nil {
+			fmt.Printf("  > %s: %s\n", title.Title, err)
+			continue
+		}
+		missing, err := missingChapters(ctx, cfg, adapter, title, path, writer.Extension())
+		if err != nil {
+			fmt.Printf("  > %s: %s\n", title.Title, err)
+			continue
+		}
+		if len(missing) == 0 {
+			fmt.Printf("  > %s: no chapter missing\n", title.Title)
+			continue
+		}
+		fmt.Printf("  > %s: %d chapters missing: %v\n", title.Title, len(missing), missing)
+		if !repair {
+			continue
+		}
+		metadata := readMetadata(ctx, adapter, title.Title, writer)
+		downloadChapters(ctx, cfg, adapter, title.Title, title.Provider, missing, path, silent, writer, metadata, processingOptions(cfg, title.Title, deviceProfile))
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	if !found && manga != "???" {
+		fmt.Printf("%s is not in the history, nothing to check.\n", manga)
+	} else if !found {
+		fmt.Println("The history is empty, nothing to check.")
+	}
+}
+
+/*
+missingChapters send the chapters of the provider list before the next chapter to download of a manga which are
+neither on disk nor recorded in the history. A recorded chapter whose file was deleted is missing too
+*/
+func missingChapters(ctx context.Context, cfg *settings.Settings, adapter fetch.Provider, title settings.Manga, path, extension string) ([]chapterid.ID, error) {
+	available, err := adapter.Chapters(ctx, title.Title)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the chapter list: %s", err)
+	}
+	downloaded, err := fetch.DownloadedChapters(path, title.Title, extension)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[chapterid.ID]bool)
+	for _, chapter := range settings.SearchChapters(*cfg, title.Title) {
+		if _, err := os.Stat(chapter.Path); err == nil {
+			recorded[chapter.Chapter] = true
+		}
+	}
+	var missing []chapterid.ID
+	for _, chapter := range available {
+		if !chapter.Less(title.Chapter) {
+			break
+		}
+		if _, ok := downloaded[chapter]; !ok && !recorded[chapter] {
+			missing = append(missing, chapter)
+		}
+	}
+	return missing, nil
+}
+
+/*
+selectChapters send the chapters to download among the available ones: from the first chapter, only the ones of the
+selection if there is one and not skipped, up to the last chapter to fetch, and no more than the maximum
+*/
+func selectChapters(available []chapterid.ID, from chapterid.ID, selection chapterid.Selection, until chapterid.ID, maxChapters int, skipped map[chapterid.ID]bool) (chapters []chapterid.ID) {
+	for _, chapter := range available {
+		if chapter.Less(from) || (selection != nil && !selection.Contains(chapter)) || skipped[chapter] {
+			continue
+		}
+		if until != "" && until.Less(chapter) {
+			break
 		}
-		*cfg = settings.UpdateHistory(*cfg, manga, chapter, provider)
+		if maxChapters > 0 && len(chapters) >= maxChapters {
+			break
+		}
+		chapters = append(chapters, chapter)
 	}
+	return
 }
 
 /*
 ProcessListCommand process the list command, highlight the mangas that have new chapters for all the suscribed
 mangas available in the history
 */
-func ProcessListCommand(cfg *settings.Settings) {
+func ProcessListCommand(ctx context.Context, cfg *settings.Settings) {
 	fmt.Println("- <List> command selected")
-	settings.DisplayHistory(cfg)
+	settings.DisplayHistory(ctx, cfg)
 }
 
 /*
 ProcessUpdateCommand allows to update the history for a downloaded manga. you can override
-the provider, or the next chapter to download.
+the provider, or the next chapter to download, and change how its pages are processed.
 */
-func ProcessUpdateCommand(cfg *settings.Settings, manga, provider string, nextChapter int) {
+func ProcessUpdateCommand(cfg *settings.Settings, manga, provider string, next string, trim, spread string) {
 	if manga == "???" {
 		fmt.Println("parameter --manga is mandatory...")
 		os.Exit(1)
 	}
+	nextChapter := settings.SearchLastChapter(*cfg, manga)
+	if next != "???" {
+		var err error
+		if nextChapter, err = chapterid.Parse(next); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	processing := settings.SearchProcessing(*cfg, manga)
+	changed := false
+	if trim != "???" {
+		switch trim {
+		case "yes":
+			processing.Trim = true
+		case "no":
+			processing.Trim = false
+		default:
+			fmt.Println("parameter --trim must be yes or no...")
+			os.Exit(1)
+		}
+		changed = true
+	}
+	if spread != "???" {
+		value, err := imaging.ParseSpread(spread)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		processing.Spread = value
+		changed = true
+	}
 	fmt.Println("- <Update> command selected, with the following parameters:")
 	fmt.Printf("  > Filter on Manga title : '%s'\n", manga)
-	if provider == "???" {
+	if provider != "???" {
 		fmt.Printf("  > Set provider to : '%s'\n", provider)
 	}
-	if nextChapter > 0 {
-		fmt.Printf("  > Set next chapter to download to %d\n", nextChapter)
+	if next != "???" {
+		fmt.Printf("  > Set next chapter to download to %s\n", nextChapter)
+	}
+	if changed {
+		fmt.Printf("  > Trim the borders of the pages : %t\n", processing.Trim)
+		fmt.Printf("  > Landscape pages : %s\n", spreadName(processing.Spread))
+		*cfg = settings.UpdateProcessing(*cfg, manga, processing)
+		if provider == "???" && next == "???" {
+			return
+		}
 	}
 	*cfg = settings.UpdateHistory(*cfg, manga, nextChapter, provider)
-}
\ No newline at end of file
+}
+
+// spreadName send the name of the way the landscape pages are handled, they are kept by default
+func spreadName(spread imaging.Spread) imaging.Spread {
+	if spread == "" {
+		return imaging.SpreadKeep
+	}
+	return spread
+}
+
+/*
+ProcessBlockCommand add a page of an existing archive to the blocklist, the pages looking like it are dropped from
+the chapters downloaded from now on
+*/
+func ProcessBlockCommand(cfg *settings.Settings, archive string, page int) {
+	if archive == "???" || page < 1 {
+		fmt.Println("parameters --archive and --page are mandatory...")
+		os.Exit(1)
+	}
+	fmt.Println("- <Block> command selected, with the following parameters:")
+	fmt.Printf("  > Archive : '%s'\n", archive)
+	fmt.Printf("  > Page to block : %d\n", page)
+	content, err := createcbz.ReadPage(archive, page)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	hash, err := imaging.HashReader(bytes.NewReader(content))
+	if err != nil {
+		fmt.Printf("unable to read page %d of %s: %s\n", page, archive, err)
+		os.Exit(1)
+	}
+	fmt.Printf("  > Hash of the page : %s\n", hash)
+	*cfg = settings.AddToBlocklist(*cfg, hash, archive, page)
+}
+
+// Volume is a range of chapters bundled together, as described in the mapping files
+type Volume struct {
+	Volume int          `json:"volume"`
+	From   chapterid.ID `json:"from"`
+	To     chapterid.ID `json:"to"`
+}
+
+/*
+ProcessBundleCommand merge the chapter archives already downloaded for a manga into volume archives. The volume is
+either given with its range of chapters, or read from a mapping file listing the range of chapters of each volume.
+The chapter archives are only removed when asked, and once the volume is written.
+*/
+func ProcessBundleCommand(cfg *settings.Settings, manga string, path string, from, to string, volume int, mapping string, remove bool) {
+	if manga == "???" {
+		fmt.Println("parameter --manga is mandatory...")
+		os.Exit(1)
+	}
+	if path == "???" {
+		path = cfg.Config.OutputPath
+	}
+	var volumes []Volume
+	if mapping != "???" {
+		byteValue, err := ioutil.ReadFile(mapping)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err = json.Unmarshal(byteValue, &volumes); err != nil {
+			fmt.Printf("unable to read the mapping file %s: %s\n", mapping, err)
+			os.Exit(1)
+		}
+	} else {
+		if volume < 0 || from == "???" || to == "???" {
+			fmt.Println("parameters --volume, --from and --to are mandatory without --mapping...")
+			os.Exit(1)
+		}
+		first, err := chapterid.Parse(from)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		last, err := chapterid.Parse(to)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		volumes = []Volume{{Volume: volume, From: first, To: last}}
+	}
+	fmt.Println("- <Bundle> command selected, with the following parameters:")
+	fmt.Printf("  > Manga title to bundle : '%s'\n", manga)
+	fmt.Printf("  > Archives read from output path '%s'\n", path)
+	if remove {
+		fmt.Println("  > The chapter archives will be removed once bundled")
+	}
+	downloaded, err := fetch.DownloadedChapters(path, manga, ".cbz")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, v := range volumes {
+		if err := bundleVolume(cfg, manga, path, v, downloaded, remove); err != nil {
+			fmt.Printf("volume %d for %s is skipped: %s\n", v.Volume, manga, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+/*
+bundleVolume write the archive of one volume from the archives of its chapters, the missing chapters are reported
+and left out
+*/
+func bundleVolume(cfg *settings.Settings, manga, path string, volume Volume, downloaded map[chapterid.ID]string, remove bool) error {
+	if volume.To.Less(volume.From) {
+		return fmt.Errorf("chapter %s is after chapter %s", volume.From, volume.To)
+	}
+	var chapters []chapterid.ID
+	for chapter := range downloaded {
+		if !chapter.Less(volume.From) && !volume.To.Less(chapter) {
+			chapters = append(chapters, chapter)
+		}
+	}
+	chapterid.Sort(chapters)
+	if len(chapters) == 0 {
+		return fmt.Errorf("none of the chapters %s to %s is downloaded", volume.From, volume.To)
+	}
+	var parts []createcbz.Part
+	for _, chapter := range chapters {
+		parts = append(parts, createcbz.Part{Archive: downloaded[chapter], Prefix: chapter.Padded()})
+	}
+	// only the whole numbers can be expected, nobody knows which decimal or special chapters exist
+	first, firstOk := volume.From.Int()
+	last, lastOk := volume.To.Int()
+	if firstOk && lastOk {
+		var missing []int
+		for chapter := first; chapter <= last; chapter++ {
+			if _, ok := downloaded[chapterid.FromInt(chapter)]; !ok {
+				missing = append(missing, chapter)
+			}
+		}
+		if len(missing) > 0 {
+			fmt.Printf("  > Volume %d: the following chapters are not downloaded and are left out: %v\n", volume.Volume, missing)
+		}
+	}
+	options := createcbz.Options{
+		Compression: cfg.Config.Compression,
+	}
+	if !cfg.Config.DisableComicInfo {
+		// the description of the series comes from the first chapter, when it has one
+		info, err := createcbz.ReadComicInfo(parts[0].Archive)
+		if err != nil || info == nil {
+			info = createcbz.NewComicInfo(manga, "", "")
+		}
+		info.Number = ""
+		info.Web = ""
+		info.Volume = volume.Volume
+		info.Title = fmt.Sprintf("Volume %d", volume.Volume)
+		options.ComicInfo = info
+	}
+	fileName := fmt.Sprintf("%s/%s/%s-v%02d.cbz", path, manga, manga, volume.Volume)
+	if err := createcbz.MergeFiles(fileName, parts, options); err != nil {
+		return err
+	}
+	fmt.Printf("  > Volume %d written to %s with %d chapters\n", volume.Volume, fileName, len(parts))
+	if remove {
+		for _, part := range parts {
+			if err := os.Remove(part.Archive); err != nil {
+				fmt.Printf("  > Unable to remove %s: %s\n", part.Archive, err)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+ProcessScanCommand rebuild the history from the cbz found in the output path, for every manga in it or only for one of
+them. The chapter of an archive is read from its ComicInfo.xml when there is one, or from its name, and the provider
+from the url in its ComicInfo.xml
+*/
+func ProcessScanCommand(cfg *settings.Settings, manga string, path string) {
+	if path == "???" {
+		path = cfg.Config.OutputPath
+	}
+	fmt.Println("- <Scan> command selected, with the following parameters:")
+	fmt.Printf("  > Scan the output path '%s'\n", path)
+	var titles []string
+	if manga != "???" {
+		fmt.Printf("  > Manga title to scan : '%s'\n", manga)
+		titles = append(titles, manga)
+	} else {
+		dirs, err := ioutil.ReadDir(path)
+		if err != nil {
+			fmt.Printf("unable to read the output path %s: %s\n", path, err)
+			os.Exit(1)
+		}
+		for _, dir := range dirs {
+			if dir.IsDir() && !strings.HasPrefix(dir.Name(), ".") {
+				titles = append(titles, dir.Name())
+			}
+		}
+	}
+	for _, title := range titles {
+		provider := cfg.Config.Provider
+		for _, known := range cfg.History.Titles {
+			if known.Title == title && known.Provider != "" {
+				provider = known.Provider
+			}
+		}
+		chapters, err := scanManga(path, title, provider)
+		if err != nil {
+			fmt.Printf("  > %s: %s\n", title, err)
+			continue
+		}
+		if len(chapters) == 0 {
+			fmt.Printf("  > %s: no chapter found\n", title)
+			continue
+		}
+		fmt.Printf("  > %s: %d chapters found\n", title, len(chapters))
+		*cfg = settings.RebuildHistory(*cfg, title, chapters)
+	}
+}
+
+/*
+scanManga read the chapters of the cbz found for a manga, the archives which are not a chapter, like the volumes,
+are left out. The provider is used for the archives which don't tell where they come from
+*/
+func scanManga(path, title, provider string) ([]settings.DownloadedChapter, error) {
+	dir := fmt.Sprintf("%s/%s", path, title)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	named, err := fetch.DownloadedChapters(path, title, ".cbz")
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]chapterid.ID)
+	for chapter, fileName := range named {
+		byName[fileName] = chapter
+	}
+	var chapters []settings.DownloadedChapter
+	for _, file := range files {
+		if file.IsDir() || strings.ToLower(filepath.Ext(file.Name())) != ".cbz" {
+			continue
+		}
+		fileName := filepath.Join(dir, file.Name())
+		chapter, ok := byName[fileName]
+		chapterProvider := provider
+		info, err := createcbz.ReadComicInfo(fileName)
+		if err != nil {
+			fmt.Printf("  > %s is skipped: %s\n", file.Name(), err)
+			continue
+		}
+		if info != nil {
+			if info.Number != "" {
+				if number, err := chapterid.Parse(info.Number); err == nil {
+					chapter, ok = number, true
+				}
+			}
+			if name, found := fetch.ProviderForURL(info.Web); found {
+				chapterProvider = name
+			}
+		}
+		if !ok {
+			continue
+		}
+		record, err := settings.DescribeChapter(chapter, chapterProvider, fileName)
+		if err != nil {
+			fmt.Printf("  > %s is skipped: %s\n", file.Name(), err)
+			continue
+		}
+		chapters = append(chapters, record)
+	}
+	return chapters, nil
+}
@@ -0,0 +1,98 @@
+package createcbz
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Part is an archive merged in a bigger one, its pages are renamed with the prefix so that they stay grouped
+type Part struct {
+	Archive string
+	Prefix  string
+}
+
+// MergeFiles merges the pages of several archives into a single one, in the order of the parts.
+// The pages of each part are kept in their natural order and renamed <prefix>-001.jpg, <prefix>-002.png, ...
+// The metadata of the parts are not copied, options.ComicInfo is written instead when it is set, completed with all
+// the pages. Like ZipFiles, the archive is written in a temporary file and only renamed once complete.
+func MergeFiles(filename string, parts []Part, options Options) error {
+
+	method := zip.Store
+	if options.Compression == Deflate {
+		method = zip.Deflate
+	}
+
+	return createArchive(filename, func(zipWriter *zip.Writer) error {
+		var pages []ComicPageInfo
+		for _, part := range parts {
+			partPages, err := mergePart(zipWriter, part, method, options.ComicInfo != nil)
+			if err != nil {
+				return &Error{Archive: filename, File: part.Archive, Err: err}
+			}
+			pages = append(pages, partPages...)
+		}
+		if options.ComicInfo != nil {
+			if err := addComicInfoToZip(zipWriter, options.ComicInfo.withPages(pages)); err != nil {
+				return &Error{Archive: filename, File: comicInfoName, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+// mergePart copy the pages of an archive, and send their description if asked
+func mergePart(zipWriter *zip.Writer, part Part, method uint16, describe bool) (pages []ComicPageInfo, err error) {
+
+	zipReader, err := zip.OpenReader(part.Archive)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+
+	entries := pageEntries(zipReader)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no page in the archive")
+	}
+
+	for i, entry := range entries {
+		name := fmt.Sprintf("%s-%03d%s", part.Prefix, i+1, strings.ToLower(path.Ext(entry.Name)))
+		if err = copyZipEntry(zipWriter, entry, name, method); err != nil {
+			return nil, err
+		}
+		if describe {
+			content, err := entry.Open()
+			if err != nil {
+				return nil, err
+			}
+			pages = append(pages, readPageInfoFrom(content, int64(entry.UncompressedSize64)))
+			content.Close()
+		}
+	}
+	return pages, nil
+}
+
+func copyZipEntry(zipWriter *zip.Writer, entry *zip.File, name string, method uint16) error {
+
+	content, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: modified,
+	}
+	header.SetMode(0644)
+
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, content)
+	return err
+}
@@ -0,0 +1,132 @@
+package createcbz
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"image"
+	"io"
+	"os"
+
+	// the decoders of the formats used by the sites, to read the size of the pages
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// ComicInfo is the metadata file read by the comics readers (Komga, Kavita, ...) to describe an archive, following
+// the ComicRack schema
+type ComicInfo struct {
+	XMLName     xml.Name        `xml:"ComicInfo"`
+	XMLNSXsi    string          `xml:"xmlns:xsi,attr"`
+	XMLNSXsd    string          `xml:"xmlns:xsd,attr"`
+	Title       string          `xml:"Title,omitempty"`
+	Series      string          `xml:"Series,omitempty"`
+	Number      string          `xml:"Number,omitempty"`
+	Volume      int             `xml:"Volume,omitempty"`
+	Summary     string          `xml:"Summary,omitempty"`
+	Writer      string          `xml:"Writer,omitempty"`
+	Web         string          `xml:"Web,omitempty"`
+	PageCount   int             `xml:"PageCount,omitempty"`
+	LanguageISO string          `xml:"LanguageISO,omitempty"`
+	Manga       string          `xml:"Manga,omitempty"`
+	Pages       []ComicPageInfo `xml:"Pages>Page,omitempty"`
+}
+
+// ComicPageInfo describe one page of the archive
+type ComicPageInfo struct {
+	Image       int    `xml:"Image,attr"`
+	Type        string `xml:"Type,attr,omitempty"`
+	ImageSize   int64  `xml:"ImageSize,attr,omitempty"`
+	ImageWidth  int    `xml:"ImageWidth,attr,omitempty"`
+	ImageHeight int    `xml:"ImageHeight,attr,omitempty"`
+}
+
+// comicInfoName is the name of the metadata file in the archive
+const comicInfoName = "ComicInfo.xml"
+
+// NewComicInfo create the metadata of a chapter of a manga, read from right to left. The pages are added when the
+// archive is written
+func NewComicInfo(series, number, title string) *ComicInfo {
+	return &ComicInfo{
+		XMLNSXsi: "http://www.w3.org/2001/XMLSchema-instance",
+		XMLNSXsd: "http://www.w3.org/2001/XMLSchema",
+		Title:    title,
+		Series:   series,
+		Number:   number,
+		Manga:    "YesAndRightToLeft",
+	}
+}
+
+// withPages send a copy of the metadata describing the given pages, in the order they are stored in the archive
+func (info ComicInfo) withPages(pages []ComicPageInfo) *ComicInfo {
+	info.Pages = nil
+	for i, page := range pages {
+		page.Image = i
+		page.Type = ""
+		if i == 0 {
+			page.Type = "FrontCover"
+		}
+		info.Pages = append(info.Pages, page)
+	}
+	info.PageCount = len(info.Pages)
+	return &info
+}
+
+// marshal send the XML document
+func (info *ComicInfo) marshal() ([]byte, error) {
+	content, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), content...), nil
+}
+
+// readPageInfo read the size of a page, and its dimensions if the format is known
+func readPageInfo(filename string) (page ComicPageInfo, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	return readPageInfoFrom(file, info.Size()), nil
+}
+
+// readPageInfoFrom read the dimensions of a page from its content, if the format is known
+func readPageInfoFrom(content io.Reader, size int64) (page ComicPageInfo) {
+	page.ImageSize = size
+	if config, _, err := image.DecodeConfig(content); err == nil {
+		page.ImageWidth = config.Width
+		page.ImageHeight = config.Height
+	}
+	return
+}
+
+// ReadComicInfo read the metadata stored in an archive, nothing is sent back if there is none
+func ReadComicInfo(archive string) (*ComicInfo, error) {
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, &Error{Archive: archive, Read: true, Err: err}
+	}
+	defer zipReader.Close()
+	for _, entry := range zipReader.File {
+		if entry.Name != comicInfoName {
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return nil, &Error{Archive: archive, File: comicInfoName, Read: true, Err: err}
+		}
+		defer content.Close()
+		// the namespaces are not read back by the decoder, they are kept from a new one
+		info := NewComicInfo("", "", "")
+		if err = xml.NewDecoder(content).Decode(info); err != nil {
+			return nil, &Error{Archive: archive, File: comicInfoName, Read: true, Err: err}
+		}
+		return info, nil
+	}
+	return nil, nil
+}
@@ -2,34 +2,123 @@ package createcbz
 
 import (
 	"archive/zip"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/francoiscolombo/gomangareaderdl/atomicfile"
 )
 
+// Error is returned when an archive could not be written, File is the page that was added when it happened. Read is
+// set when it's an archive we were reading which is wrong, File is then the entry that was read
+type Error struct {
+	Archive string
+	File    string
+	Read    bool
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Read && e.File == "" {
+		return fmt.Sprintf("unable to read archive %s: %s", e.Archive, e.Err)
+	}
+	if e.Read {
+		return fmt.Sprintf("unable to read %s from archive %s: %s", e.File, e.Archive, e.Err)
+	}
+	if e.File == "" {
+		return fmt.Sprintf("unable to create archive %s: %s", e.Archive, e.Err)
+	}
+	return fmt.Sprintf("unable to add %s to archive %s: %s", e.File, e.Archive, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Compression is the way the pages are stored in the archive
+type Compression string
+
+const (
+	// Store keeps the pages as they are, the images are already compressed so it's the best choice most of the time
+	Store Compression = "store"
+	// Deflate compresses the pages, which is only worth it for uncompressed images
+	Deflate Compression = "deflate"
+)
+
+// Options tune the way an archive is written. If ComicInfo is set, it is completed with the pages and stored in
+// the archive
+type Options struct {
+	Compression Compression
+	ComicInfo   *ComicInfo
+}
+
+// modified is the date of every entry of the archives, so that the same pages always give the same archive
+var modified = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 // ZipFiles compresses one or many files into a single zip archive file.
 // Param 1: filename is the output zip file's name.
 // Param 2: files is a list of files to add to the zip.
-func ZipFiles(filename string, files []string) error {
+// Param 3: options tune the archive.
+// The files are stored flat, in the given order and renamed with their position in it (001.jpg, 002.png, ...).
+// The caller sends the pages in reading order, the names of the files are not looked at.
+// The archive is written in a temporary file next to it, and only renamed to its real name once complete.
+func ZipFiles(filename string, files []string, options Options) error {
 
-	newZipFile, err := os.Create(filename)
-	if err != nil {
-		return err
+	width := len(strconv.Itoa(len(files)))
+	if width < 3 {
+		width = 3
+	}
+	method := zip.Store
+	if options.Compression == Deflate {
+		method = zip.Deflate
 	}
-	defer newZipFile.Close()
 
-	zipWriter := zip.NewWriter(newZipFile)
-	defer zipWriter.Close()
+	return createArchive(filename, func(zipWriter *zip.Writer) error {
+		// Add files to zip
+		var pages []ComicPageInfo
+		for i, file := range files {
+			name := fmt.Sprintf("%0*d%s", width, i+1, strings.ToLower(filepath.Ext(file)))
+			if err := addFileToZip(zipWriter, file, name, method); err != nil {
+				return &Error{Archive: filename, File: file, Err: err}
+			}
+			if options.ComicInfo != nil {
+				page, err := readPageInfo(file)
+				if err != nil {
+					return &Error{Archive: filename, File: file, Err: err}
+				}
+				pages = append(pages, page)
+			}
+		}
+		if options.ComicInfo != nil {
+			if err := addComicInfoToZip(zipWriter, options.ComicInfo.withPages(pages)); err != nil {
+				return &Error{Archive: filename, File: comicInfoName, Err: err}
+			}
+		}
+		return nil
+	})
+}
 
-	// Add files to zip
-	for _, file := range files {
-		if err = addFileToZip(zipWriter, file); err != nil {
+// createArchive write an archive in a temporary file next to it, and only rename it to its real name once complete.
+// The fill function adds the entries.
+func createArchive(filename string, fill func(zipWriter *zip.Writer) error) error {
+	err := atomicfile.Write(filename, func(w io.Writer) error {
+		zipWriter := zip.NewWriter(w)
+		if err := fill(zipWriter); err != nil {
 			return err
 		}
+		return zipWriter.Close()
+	})
+	if _, ok := err.(*Error); err != nil && !ok {
+		return &Error{Archive: filename, Err: err}
 	}
-	return nil
+	return err
 }
 
-func addFileToZip(zipWriter *zip.Writer, filename string) error {
+func addFileToZip(zipWriter *zip.Writer, filename, name string, method uint16) error {
 
 	fileToZip, err := os.Open(filename)
 	if err != nil {
@@ -37,29 +126,40 @@ func addFileToZip(zipWriter *zip.Writer, filename string) error {
 	}
 	defer fileToZip.Close()
 
-	// Get the file information
-	info, err := fileToZip.Stat()
+	// nothing taken from the file itself, so that the archive only depends on the content of the pages
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: modified,
+	}
+	header.SetMode(0644)
+
+	writer, err := zipWriter.CreateHeader(header)
 	if err != nil {
 		return err
 	}
+	_, err = io.Copy(writer, fileToZip)
+	return err
+}
 
-	header, err := zip.FileInfoHeader(info)
+func addComicInfoToZip(zipWriter *zip.Writer, info *ComicInfo) error {
+
+	content, err := info.marshal()
 	if err != nil {
 		return err
 	}
 
-	// Using FileInfoHeader() above only uses the basename of the file. If we want
-	// to preserve the folder structure we can overwrite this with the full path.
-	header.Name = filename
-
-	// Change to deflate to gain better compression
-	// see http://golang.org/pkg/archive/zip/#pkg-constants
-	header.Method = zip.Deflate
+	header := &zip.FileHeader{
+		Name:     comicInfoName,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	header.SetMode(0644)
 
 	writer, err := zipWriter.CreateHeader(header)
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(writer, fileToZip)
+	_, err = writer.Write(content)
 	return err
 }
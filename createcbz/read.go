@@ -0,0 +1,58 @@
+package createcbz
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/francoiscolombo/gomangareaderdl/natural"
+)
+
+// pageEntries send the pages of an archive in reading order, without its metadata
+func pageEntries(zipReader *zip.ReadCloser) []*zip.File {
+	var entries []*zip.File
+	for _, entry := range zipReader.File {
+		if entry.FileInfo().IsDir() || entry.Name == comicInfoName {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return natural.Less(entries[i].Name, entries[j].Name)
+	})
+	return entries
+}
+
+// ReadPage send the content of a page of an archive, the pages are counted from 1 in reading order
+func ReadPage(archive string, page int) ([]byte, error) {
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, &Error{Archive: archive, Read: true, Err: err}
+	}
+	defer zipReader.Close()
+	entries := pageEntries(zipReader)
+	if page < 1 || page > len(entries) {
+		return nil, &Error{Archive: archive, Read: true, Err: fmt.Errorf("no page %d, it has %d pages", page, len(entries))}
+	}
+	content, err := entries[page-1].Open()
+	if err != nil {
+		return nil, &Error{Archive: archive, File: entries[page-1].Name, Read: true, Err: err}
+	}
+	defer content.Close()
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, &Error{Archive: archive, File: entries[page-1].Name, Read: true, Err: err}
+	}
+	return data, nil
+}
+
+// CountPages send the number of pages of an archive
+func CountPages(archive string) (int, error) {
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return 0, &Error{Archive: archive, Read: true, Err: err}
+	}
+	defer zipReader.Close()
+	return len(pageEntries(zipReader)), nil
+}
@@ -0,0 +1,315 @@
+package createepub
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	// the decoders of the formats used by the sites, to read the size of the pages
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/francoiscolombo/gomangareaderdl/atomicfile"
+)
+
+// Book is what goes in an EPUB: the description of the manga and its chapters
+type Book struct {
+	Title    string
+	Author   string
+	Summary  string
+	Language string
+	Chapters []Chapter
+}
+
+// Chapter is a chapter of the book, with its pages in reading order
+type Chapter struct {
+	Title string
+	Pages []string
+}
+
+// Error is returned when an EPUB could not be written, File is the page that was added when it happened
+type Error struct {
+	Book string
+	File string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("unable to create epub %s: %s", e.Book, e.Err)
+	}
+	return fmt.Sprintf("unable to add %s to epub %s: %s", e.File, e.Book, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// page is one image of the book, with the xhtml page displaying it
+type page struct {
+	ID        string
+	Image     string
+	MediaType string
+	Document  string
+	Width     int
+	Height    int
+	Source    string
+}
+
+// navPoint is an entry of the table of contents, pointing to the first page of a chapter
+type navPoint struct {
+	Title    string
+	Document string
+}
+
+// modified is the date of every entry of the EPUB, so that the same pages always give the same file
+var modified = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// defaultWidth and defaultHeight are used for the pages whose size can't be read
+const (
+	defaultWidth  = 800
+	defaultHeight = 1200
+)
+
+// Write create a fixed-layout EPUB 3 from the pages of the chapters: one page per image, read from right to left,
+// the first image as cover and a table of contents with the chapters.
+// Like the cbz, the EPUB is written in a temporary file next to it, and only renamed to its real name once complete.
+func Write(filename string, book Book) (err error) {
+
+	var pages []page
+	var toc []navPoint
+	for c, chapter := range book.Chapters {
+		for p, source := range chapter.Pages {
+			id := fmt.Sprintf("c%03d_p%03d", c+1, p+1)
+			width, height := imageSize(source)
+			pg := page{
+				ID:        id,
+				Image:     "images/" + id + strings.ToLower(filepath.Ext(source)),
+				MediaType: mediaType(source),
+				Document:  "pages/" + id + ".xhtml",
+				Width:     width,
+				Height:    height,
+				Source:    source,
+			}
+			if p == 0 {
+				toc = append(toc, navPoint{Title: chapter.Title, Document: pg.Document})
+			}
+			pages = append(pages, pg)
+		}
+	}
+	if len(pages) == 0 {
+		return &Error{Book: filename, Err: fmt.Errorf("no page to write")}
+	}
+
+	err = atomicfile.Write(filename, func(w io.Writer) error {
+		zipWriter := zip.NewWriter(w)
+
+		// the mimetype must be the first entry, and not compressed
+		if err := addContent(zipWriter, "mimetype", zip.Store, []byte("application/epub+zip")); err != nil {
+			return &Error{Book: filename, Err: err}
+		}
+		if err := addContent(zipWriter, "META-INF/container.xml", zip.Deflate, []byte(containerXML)); err != nil {
+			return &Error{Book: filename, Err: err}
+		}
+		data := struct {
+			Book       Book
+			Identifier string
+			Modified   string
+			Pages      []page
+			TOC        []navPoint
+		}{
+			Book:       book,
+			Identifier: identifier(book),
+			Modified:   modified.Format(time.RFC3339),
+			Pages:      pages,
+			TOC:        toc,
+		}
+		if err := addTemplate(zipWriter, "OEBPS/content.opf", packageTemplate, data); err != nil {
+			return &Error{Book: filename, Err: err}
+		}
+		if err := addTemplate(zipWriter, "OEBPS/nav.xhtml", navTemplate, data); err != nil {
+			return &Error{Book: filename, Err: err}
+		}
+		for _, pg := range pages {
+			if err := addTemplate(zipWriter, "OEBPS/"+pg.Document, pageTemplate, pg); err != nil {
+				return &Error{Book: filename, File: pg.Source, Err: err}
+			}
+			if err := addFile(zipWriter, "OEBPS/"+pg.Image, pg.Source); err != nil {
+				return &Error{Book: filename, File: pg.Source, Err: err}
+			}
+		}
+
+		return zipWriter.Close()
+	})
+	if _, ok := err.(*Error); err != nil && !ok {
+		return &Error{Book: filename, Err: err}
+	}
+	return err
+}
+
+func addContent(zipWriter *zip.Writer, name string, method uint16, content []byte) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: modified,
+	}
+	header.SetMode(0644)
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(content)
+	return err
+}
+
+func addTemplate(zipWriter *zip.Writer, name string, tmpl *template.Template, data interface{}) error {
+	var content bytes.Buffer
+	content.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	if err := tmpl.Execute(&content, data); err != nil {
+		return err
+	}
+	return addContent(zipWriter, name, zip.Deflate, content.Bytes())
+}
+
+func addFile(zipWriter *zip.Writer, name, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modified,
+	}
+	header.SetMode(0644)
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
+
+// imageSize read the dimensions of a page, the pages of a fixed-layout EPUB must have one
+func imageSize(filename string) (width, height int) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return defaultWidth, defaultHeight
+	}
+	defer file.Close()
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return defaultWidth, defaultHeight
+	}
+	return config.Width, config.Height
+}
+
+// mediaType send the media type of a page from its extension
+func mediaType(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// identifier build a stable identifier for the book, so that an e-reader recognizes it when it is written again
+func identifier(book Book) string {
+	h := sha1.New()
+	io.WriteString(h, book.Title)
+	for _, chapter := range book.Chapters {
+		io.WriteString(h, "\x00"+chapter.Title)
+	}
+	sum := h.Sum(nil)
+	// a version 5 UUID, built from the hash
+	sum[6] = (sum[6] & 0x0f) | 0x50
+	sum[8] = (sum[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+const containerXML = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// the templates only escape the texts coming from the sites, everything else is built by us
+
+var packageTemplate = template.Must(template.New("package").Parse(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">{{.Identifier}}</dc:identifier>
+    <dc:title>{{.Book.Title | html}}</dc:title>
+    {{- if .Book.Author}}
+    <dc:creator>{{.Book.Author | html}}</dc:creator>
+    {{- end}}
+    {{- if .Book.Summary}}
+    <dc:description>{{.Book.Summary | html}}</dc:description>
+    {{- end}}
+    <dc:language>{{if .Book.Language}}{{.Book.Language | html}}{{else}}en{{end}}</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+    <meta property="rendition:layout">pre-paginated</meta>
+    <meta property="rendition:orientation">portrait</meta>
+    <meta property="rendition:spread">none</meta>
+    <meta name="cover" content="img_{{(index .Pages 0).ID}}"/>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    {{- range $i, $page := .Pages}}
+    <item id="img_{{$page.ID}}" href="{{$page.Image}}" media-type="{{$page.MediaType}}"{{if eq $i 0}} properties="cover-image"{{end}}/>
+    <item id="{{$page.ID}}" href="{{$page.Document}}" media-type="application/xhtml+xml"/>
+    {{- end}}
+  </manifest>
+  <spine page-progression-direction="rtl">
+    {{- range .Pages}}
+    <itemref idref="{{.ID}}"/>
+    {{- end}}
+  </spine>
+</package>
+`))
+
+var navTemplate = template.Must(template.New("nav").Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+  <head>
+    <title>{{.Book.Title | html}}</title>
+  </head>
+  <body>
+    <nav epub:type="toc" id="toc">
+      <ol>
+        {{- range .TOC}}
+        <li><a href="{{.Document}}">{{.Title | html}}</a></li>
+        {{- end}}
+      </ol>
+    </nav>
+  </body>
+</html>
+`))
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+  <head>
+    <title>{{.ID}}</title>
+    <meta name="viewport" content="width={{.Width}}, height={{.Height}}"/>
+    <style>html, body { margin: 0; padding: 0; } img { display: block; width: {{.Width}}px; height: {{.Height}}px; }</style>
+  </head>
+  <body>
+    <img src="../{{.Image}}" alt="{{.ID}}"/>
+  </body>
+</html>
+`))
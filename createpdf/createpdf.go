@@ -0,0 +1,201 @@
+package createpdf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/francoiscolombo/gomangareaderdl/atomicfile"
+)
+
+// Book is what goes in a PDF: the description of the manga and its chapters
+type Book struct {
+	Title    string
+	Author   string
+	Subject  string
+	Chapters []Chapter
+}
+
+// Chapter is a chapter of the book, with its pages in reading order
+type Chapter struct {
+	Title string
+	Pages []string
+}
+
+// Error is returned when a PDF could not be written, File is the page that was added when it happened
+type Error struct {
+	Book string
+	File string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("unable to create pdf %s: %s", e.Book, e.Err)
+	}
+	return fmt.Sprintf("unable to add %s to pdf %s: %s", e.File, e.Book, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// pdfWriter write the objects of a PDF one after the other, keeping their offsets for the cross-reference table
+type pdfWriter struct {
+	w       *bufio.Writer
+	offset  int64
+	offsets map[int]int64
+}
+
+func (pw *pdfWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return n, err
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(pw, format, args...)
+}
+
+// object write an object whose content is a dictionary or an array
+func (pw *pdfWriter) object(id int, content string) {
+	pw.offsets[id] = pw.offset
+	pw.printf("%d 0 obj\n%s\nendobj\n", id, content)
+}
+
+// stream write an object whose content is a stream, the data are copied from the reader
+func (pw *pdfWriter) stream(id int, dictionary string, length int64, data io.Reader) error {
+	pw.offsets[id] = pw.offset
+	pw.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", id, dictionary, length)
+	if _, err := io.CopyN(pw, data, length); err != nil {
+		return err
+	}
+	pw.printf("\nendstream\nendobj\n")
+	return nil
+}
+
+// Write create a PDF from the pages of the chapters: one page per image, sized to the image, with the title and the
+// chapters in the document metadata and an outline entry per chapter when there are several of them. The JPEG and
+// most of the PNG images are embedded as they are, without being encoded again.
+// Like the cbz, the PDF is written in a temporary file next to it, and only renamed to its real name once complete.
+func Write(filename string, book Book) (err error) {
+
+	var images []*pageImage
+	var firstPages []int
+	for _, chapter := range book.Chapters {
+		firstPages = append(firstPages, len(images))
+		for _, source := range chapter.Pages {
+			img, err := readImage(source)
+			if err != nil {
+				return &Error{Book: filename, File: source, Err: err}
+			}
+			images = append(images, img)
+		}
+	}
+	if len(images) == 0 {
+		return &Error{Book: filename, Err: fmt.Errorf("no page to write")}
+	}
+
+	err = atomicfile.Write(filename, func(w io.Writer) error {
+		// the objects are numbered in advance: the catalog, the page tree, the metadata and the outline root, then
+		// three objects per page (page, content and image) and at last one per outline entry
+		const (
+			catalogID  = 1
+			pagesID    = 2
+			infoID     = 3
+			outlinesID = 4
+			firstID    = 5
+		)
+		pageID := func(i int) int { return firstID + 3*i }
+		outlineID := func(i int) int { return firstID + 3*len(images) + i }
+		withOutline := len(book.Chapters) > 1
+
+		pw := &pdfWriter{w: bufio.NewWriter(w), offsets: make(map[int]int64)}
+		pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+		catalog := fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /ViewerPreferences << /Direction /R2L >>", pagesID)
+		if withOutline {
+			catalog += fmt.Sprintf(" /Outlines %d 0 R /PageMode /UseOutlines", outlinesID)
+		}
+		pw.object(catalogID, catalog+" >>")
+
+		var kids []string
+		for i := range images {
+			kids = append(kids, fmt.Sprintf("%d 0 R", pageID(i)))
+		}
+		pw.object(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(images)))
+
+		info := fmt.Sprintf("<< /Title %s /Producer %s", text(book.Title), text("gomangareaderdl"))
+		if book.Author != "" {
+			info += " /Author " + text(book.Author)
+		}
+		if book.Subject != "" {
+			info += " /Subject " + text(book.Subject)
+		}
+		pw.object(infoID, info+" >>")
+
+		if withOutline {
+			pw.object(outlinesID, fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>",
+				outlineID(0), outlineID(len(book.Chapters)-1), len(book.Chapters)))
+		} else {
+			pw.object(outlinesID, "<< /Type /Outlines /Count 0 >>")
+		}
+
+		for i, img := range images {
+			id := pageID(i)
+			pw.object(id, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Contents %d 0 R /Resources << /XObject << /Im0 %d 0 R >> >> >>",
+				pagesID, img.width, img.height, id+1, id+2))
+			content := fmt.Sprintf("q %d 0 0 %d 0 0 cm /Im0 Do Q", img.width, img.height)
+			if err := pw.stream(id+1, "", int64(len(content)), strings.NewReader(content)); err != nil {
+				return &Error{Book: filename, File: img.source, Err: err}
+			}
+			if err := img.write(pw, id+2); err != nil {
+				return &Error{Book: filename, File: img.source, Err: err}
+			}
+		}
+
+		if withOutline {
+			for i, chapter := range book.Chapters {
+				entry := fmt.Sprintf("<< /Title %s /Parent %d 0 R /Dest [%d 0 R /Fit]", text(chapter.Title), outlinesID, pageID(firstPages[i]))
+				if i > 0 {
+					entry += fmt.Sprintf(" /Prev %d 0 R", outlineID(i-1))
+				}
+				if i < len(book.Chapters)-1 {
+					entry += fmt.Sprintf(" /Next %d 0 R", outlineID(i+1))
+				}
+				pw.object(outlineID(i), entry+" >>")
+			}
+		}
+
+		// the cross-reference table, every entry must be exactly 20 bytes long
+		count := firstID + 3*len(images)
+		if withOutline {
+			count += len(book.Chapters)
+		}
+		xref := pw.offset
+		pw.printf("xref\n0 %d\n0000000000 65535 f \n", count)
+		for id := 1; id < count; id++ {
+			pw.printf("%010d 00000 n \n", pw.offsets[id])
+		}
+		pw.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", count, catalogID, infoID, xref)
+
+		return pw.w.Flush()
+	})
+	if _, ok := err.(*Error); err != nil && !ok {
+		return &Error{Book: filename, Err: err}
+	}
+	return err
+}
+
+// text encode a string for the PDF metadata, in UTF-16 so that any language can be used
+func text(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, c := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", c)
+	}
+	b.WriteString(">")
+	return b.String()
+}
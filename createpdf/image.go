@@ -0,0 +1,245 @@
+package createpdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+
+	// the decoders of the other formats used by the sites, these images have to be encoded again
+	_ "image/gif"
+	_ "image/png"
+)
+
+// pageImage is an image to embed in the PDF, with what is needed to write its XObject
+type pageImage struct {
+	source     string
+	width      int
+	height     int
+	dictionary string
+	// the image data are either parts of the source file, or encoded again in memory
+	parts [][2]int64
+	data  []byte
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// errWebP is returned for the WebP images, there is no decoder for them
+var errWebP = fmt.Errorf("webp images can't be added to a pdf, use another format for this manga")
+
+/*
+readImage read what is needed to embed an image: the JPEG are embedded as they are, the PNG too when the PDF
+supports their format, and the others are decoded and encoded again. The WebP images are rejected
+*/
+func readImage(source string) (*pageImage, error) {
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(file, header)
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	switch {
+	case n >= 3 && bytes.Equal(header[:3], []byte{0xff, 0xd8, 0xff}):
+		if img, err := readJPEG(source, file); err == nil {
+			return img, nil
+		}
+	case n >= 8 && bytes.Equal(header[:8], pngSignature):
+		if img, err := readPNG(source, file); err == nil {
+			return img, nil
+		}
+	case n == 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return nil, errWebP
+	}
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return encodeImage(source, file)
+}
+
+/*
+readJPEG keep the JPEG data as they are, the PDF readers decode them with the DCTDecode filter
+*/
+func readJPEG(source string, file *os.File) (*pageImage, error) {
+	config, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	colorSpace := "/DeviceRGB"
+	switch config.ColorModel {
+	case color.GrayModel:
+		colorSpace = "/DeviceGray"
+	case color.CMYKModel:
+		// the CMYK JPEG are written inverted by nearly all the tools
+		colorSpace = "/DeviceCMYK /Decode [1 0 1 0 1 0 1 0]"
+	}
+	return &pageImage{
+		source:     source,
+		width:      config.Width,
+		height:     config.Height,
+		dictionary: fmt.Sprintf("/ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode", colorSpace),
+		parts:      [][2]int64{{0, info.Size()}},
+	}, nil
+}
+
+/*
+readPNG keep the compressed data of the PNG as they are, the PDF readers decode them with the FlateDecode filter and
+the PNG predictors. Only the non interlaced images without transparency and with 8 bits at most per component can be
+embedded that way, the PDF 1.4 does not allow 16 bits
+*/
+func readPNG(source string, file *os.File) (*pageImage, error) {
+	img := &pageImage{source: source}
+	var bitDepth, colorType byte
+	var palette []byte
+	reader := bufio.NewReader(file)
+	offset := int64(len(pngSignature))
+	if _, err := reader.Discard(len(pngSignature)); err != nil {
+		return nil, err
+	}
+	for {
+		var chunk struct {
+			Length uint32
+			Type   [4]byte
+		}
+		if err := binary.Read(reader, binary.BigEndian, &chunk); err != nil {
+			return nil, err
+		}
+		offset += 8
+		length := int64(chunk.Length)
+		switch string(chunk.Type[:]) {
+		case "IHDR":
+			ihdr := make([]byte, length)
+			if _, err := io.ReadFull(reader, ihdr); err != nil {
+				return nil, err
+			}
+			if len(ihdr) < 13 || ihdr[12] != 0 {
+				return nil, fmt.Errorf("interlaced png")
+			}
+			img.width = int(binary.BigEndian.Uint32(ihdr[0:4]))
+			img.height = int(binary.BigEndian.Uint32(ihdr[4:8]))
+			bitDepth, colorType = ihdr[8], ihdr[9]
+		case "PLTE":
+			palette = make([]byte, length)
+			if _, err := io.ReadFull(reader, palette); err != nil {
+				return nil, err
+			}
+		case "tRNS":
+			return nil, fmt.Errorf("png with transparency")
+		case "IDAT":
+			img.parts = append(img.parts, [2]int64{offset, length})
+			if _, err := reader.Discard(int(length)); err != nil {
+				return nil, err
+			}
+		case "IEND":
+			return img.pngDictionary(bitDepth, colorType, palette)
+		default:
+			if _, err := reader.Discard(int(length)); err != nil {
+				return nil, err
+			}
+		}
+		// the CRC of the chunk
+		if _, err := reader.Discard(4); err != nil {
+			return nil, err
+		}
+		offset += length + 4
+	}
+}
+
+func (img *pageImage) pngDictionary(bitDepth, colorType byte, palette []byte) (*pageImage, error) {
+	if bitDepth > 8 {
+		return nil, fmt.Errorf("png with %d bits per component", bitDepth)
+	}
+	var colorSpace string
+	var colors int
+	switch colorType {
+	case 0:
+		colorSpace, colors = "/DeviceGray", 1
+	case 2:
+		colorSpace, colors = "/DeviceRGB", 3
+	case 3:
+		if len(palette) == 0 {
+			return nil, fmt.Errorf("png without palette")
+		}
+		colorSpace, colors = fmt.Sprintf("[/Indexed /DeviceRGB %d <%X>]", len(palette)/3-1, palette), 1
+	default:
+		return nil, fmt.Errorf("png with alpha channel")
+	}
+	if len(img.parts) == 0 {
+		return nil, fmt.Errorf("png without data")
+	}
+	img.dictionary = fmt.Sprintf("/ColorSpace %s /BitsPerComponent %d /Filter /FlateDecode /DecodeParms << /Predictor 15 /Colors %d /BitsPerComponent %d /Columns %d >>",
+		colorSpace, bitDepth, colors, bitDepth, img.width)
+	return img, nil
+}
+
+/*
+encodeImage decode an image the PDF can't embed as it is, and compress its pixels on a white background with 8 bits
+per component
+*/
+func encodeImage(source string, file *os.File) (*pageImage, error) {
+	decoded, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	bounds := decoded.Bounds()
+	var data bytes.Buffer
+	compressor := zlib.NewWriter(&data)
+	row := make([]byte, 0, bounds.Dx()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := decoded.At(x, y).RGBA()
+			// the transparent parts become white
+			white := 0xffff - a
+			row = append(row, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+		if _, err := compressor.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+	return &pageImage{
+		source:     source,
+		width:      bounds.Dx(),
+		height:     bounds.Dy(),
+		dictionary: "/ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode",
+		data:       data.Bytes(),
+	}, nil
+}
+
+/*
+write add the image XObject to the PDF
+*/
+func (img *pageImage) write(pw *pdfWriter, id int) error {
+	dictionary := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d %s", img.width, img.height, img.dictionary)
+	if img.data != nil {
+		return pw.stream(id, dictionary, int64(len(img.data)), bytes.NewReader(img.data))
+	}
+	file, err := os.Open(img.source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var length int64
+	var readers []io.Reader
+	for _, part := range img.parts {
+		length += part[1]
+		readers = append(readers, io.NewSectionReader(file, part[0], part[1]))
+	}
+	return pw.stream(id, dictionary, length, io.MultiReader(readers...))
+}
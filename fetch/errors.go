@@ -0,0 +1,64 @@
+package fetch
+
+import (
+	"fmt"
+	"time"
+)
+
+// NetworkError is returned when a site could not be reached at all
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("network error while trying to get %s: %s", e.URL, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is returned when a site answers with another HTTP status than 200
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code error while trying to get %s: %s", e.URL, e.Status)
+}
+
+// ParseError is returned when a page could not be parsed, or does not contain what the provider is searching for.
+// URL is empty when it's a value given by the user which is wrong, like a chapter or a provider
+type ParseError struct {
+	URL string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	if e.URL == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("unable to parse %s: %s", e.URL, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// FileError is returned when the pages or the archive of a chapter could not be written on disk
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("unable to write %s: %s", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
@@ -1,219 +1,408 @@
 package fetch
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"github.com/francoiscolombo/gomangareaderdl/createcbz"
-	"github.com/schollz/progressbar/v2"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+	"github.com/francoiscolombo/gomangareaderdl/imaging"
+	"github.com/francoiscolombo/gomangareaderdl/output"
+	"github.com/schollz/progressbar/v2"
 )
 
 /*
-CreateCBZ create a readable comics archive from the pages downloaded and clean the temporary directory
- */
-func CreateCBZ(outputPath, pagesPath, title string, chapter int) {
-	// List of Files to Zip
-	fmt.Printf("\ncreate %s ... ", fmt.Sprintf("%s-%03d.cbz", title, chapter))
-	var files []string
-	outputCBZ := fmt.Sprintf("%s/%s-%03d.cbz", outputPath, title, chapter)
-	err := filepath.Walk(pagesPath, func(path string, info os.FileInfo, err error) error {
-		src, err := os.Stat(path)
-		if err != nil {
-			// still does not exists? then something wrong, exit in panic mode.
-			panic(err)
+CreateArchive write the pages downloaded for a chapter in the chosen format and clean the staging directory, the
+name of the file written is sent back
+*/
+func CreateArchive(format output.Format, outputPath, pagesPath, title string, chapter chapterid.ID, book output.Book) (string, error) {
+	if err := chapter.Validate(); err != nil {
+		return "", &ParseError{Err: err}
+	}
+	name := ArchiveName(title, chapter, format.Extension())
+	fmt.Printf("\ncreate %s ... ", name)
+	fileName := fmt.Sprintf("%s/%s", outputPath, name)
+	if err := format.Write(fileName, book); err != nil {
+		return "", &FileError{Path: fileName, Err: err}
+	}
+	os.RemoveAll(pagesPath)
+	fmt.Println("done")
+	return fileName, nil
+}
+
+// Archive describes the file written for a chapter: where it is, its number of pages, its size and the SHA-256 of
+// its content
+type Archive struct {
+	Path   string
+	Pages  int
+	Size   int64
+	SHA256 string
+}
+
+/*
+DescribeArchive read the size and compute the hash of the file written for a chapter. The folders are read file by
+file in lexical order, their size and their hash cover the names and the content of all their files
+*/
+func DescribeArchive(fileName string, pages int) (Archive, error) {
+	archive := Archive{Path: fileName, Pages: pages}
+	hash := sha256.New()
+	root := filepath.Dir(fileName)
+	err := filepath.Walk(fileName, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
 		}
-		if !src.IsDir() {
-			files = append(files, path)
+		if path != fileName {
+			name, _ := filepath.Rel(root, path)
+			io.WriteString(hash, filepath.ToSlash(name))
 		}
-		return nil
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		size, err := io.Copy(hash, file)
+		archive.Size += size
+		return err
 	})
 	if err != nil {
-		panic(err)
+		return Archive{}, &FileError{Path: fileName, Err: err}
 	}
-	if err := createcbz.ZipFiles(outputCBZ, files); err != nil {
-		panic(err)
+	archive.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return archive, nil
+}
+
+/*
+ArchiveName send the name of the file written for a chapter: the title and the chapter, padded so that the files
+are listed in reading order
+*/
+func ArchiveName(title string, chapter chapterid.ID, extension string) string {
+	return fmt.Sprintf("%s-%s%s", title, chapter.Padded(), extension)
+}
+
+/*
+DownloadedChapters search the files written for the chapters of a manga with the given extension, and send them by
+chapter. The files which are not named like ArchiveName does, like the volumes, are left out. Without extension the
+chapters are directories, and the files of the other formats are left out too
+*/
+func DownloadedChapters(outputPath, title, extension string) (map[chapterid.ID]string, error) {
+	dir := fmt.Sprintf("%s/%s", outputPath, title)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, &FileError{Path: dir, Err: err}
 	}
+	chapters := make(map[chapterid.ID]string)
+	prefix := title + "-"
 	for _, file := range files {
-		os.Remove(file)
+		name := file.Name()
+		written := file.Mode().IsRegular()
+		if extension == "" {
+			written = file.IsDir()
+		}
+		if !written || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, extension) {
+			continue
+		}
+		value := strings.TrimSuffix(strings.TrimPrefix(name, prefix), extension)
+		chapter, err := chapterid.Parse(value)
+		if err != nil || ArchiveName(title, chapter, extension) != name || isVolume(chapter) || isArchive(value) {
+			continue
+		}
+		chapters[chapter] = filepath.Join(dir, name)
 	}
-	os.Remove(pagesPath)
-	fmt.Println("done")
+	return chapters, nil
+}
+
+// isArchive check if the name of a chapter ends with the extension of one of the formats, like a cbz seen from the
+// folder format
+func isArchive(value string) bool {
+	extension := strings.ToLower(filepath.Ext(value))
+	for _, known := range output.Extensions() {
+		if extension == known {
+			return true
+		}
+	}
+	return false
+}
+
+// isVolume check if a chapter is in fact a volume written by the bundle command, like v01
+func isVolume(chapter chapterid.ID) bool {
+	value := chapter.String()
+	if chapter.IsNumber() || len(value) < 2 || value[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(value[1:])
+	return err == nil
 }
 
 /*
-DownloadImage simply download an image and store it in the proper directory
- */
-func DownloadImage(path string, page int, url string) {
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("cache-control", "no-cache")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		// handle error
-		log.Fatal(err)
+DownloadImage simply download an image and store it in the proper directory, with the extension of its real format.
+The image is written in a ".part" file renamed once complete, so that an interrupted download never leaves a
+truncated page behind. What is not an image, like the HTML error pages of some sites, an image cut before its end or
+shorter than announced, or an image which can't be decoded is rejected with an ImageError, and downloaded again as
+long as the retry policy allows it
+*/
+func DownloadImage(ctx context.Context, provider Provider, path string, page int, url string) (fileName string, err error) {
+	partName := fmt.Sprintf("%s/page_%03d.part", path, page)
+	var format imageFormat
+	err = clientFor(provider.Name()).do(ctx, url, func(res *http.Response) error {
+		body := bufio.NewReaderSize(res.Body, sniffLength)
+		peeked, err := body.Peek(sniffLength)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return &NetworkError{URL: url, Err: err}
+		}
+		// the peeked bytes are overwritten by the next reads
+		header := append([]byte(nil), peeked...)
+		if format, err = sniffImage(header, res.Header.Get("Content-Type")); err != nil {
+			return &ImageError{URL: url, Err: err}
+		}
+		//open a file for writing
+		file, err := os.Create(partName)
+		if err != nil {
+			return &FileError{Path: partName, Err: err}
+		}
+		// Use io.Copy to just dump the response body to the file. This supports huge files
+		size, err := io.Copy(file, body)
+		file.Close()
+		if err != nil {
+			return &NetworkError{URL: url, Err: err}
+		}
+		trailer, err := readTrailer(partName)
+		if err != nil {
+			return &FileError{Path: partName, Err: err}
+		}
+		// the length is unknown when the site compresses the response, the other checks are still done
+		if res.ContentLength >= 0 && size != res.ContentLength {
+			return &ImageError{URL: url, Err: fmt.Errorf("received %d bytes instead of %d", size, res.ContentLength)}
+		}
+		if !format.complete(header, trailer, size) {
+			return &ImageError{URL: url, Err: fmt.Errorf("truncated image")}
+		}
+		if err = format.validate(partName); err != nil {
+			return &ImageError{URL: url, Err: err}
+		}
+		return nil
+	})
+	if err == nil {
+		fileName = fmt.Sprintf("%s/page_%03d%s", path, page, format.extension)
+		if err = os.Rename(partName, fileName); err != nil {
+			err = &FileError{Path: fileName, Err: err}
+		}
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		log.Fatalf("status code error while trying to extract images from %s: %d %s", url, res.StatusCode, res.Status)
+	if err != nil {
+		os.Remove(partName)
 	}
-	//open a file for writing
-	file, err := os.Create(fmt.Sprintf("%s/page_%03d.jpg", path, page))
+	return
+}
+
+/*
+readTrailer read the last bytes of a file, where the images have their end marker
+*/
+func readTrailer(fileName string) ([]byte, error) {
+	file, err := os.Open(fileName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer file.Close()
-	// Use io.Copy to just dump the response body to the file. This supports huge files
-	_, err = io.Copy(file, res.Body)
+	info, err := file.Stat()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	offset := info.Size() - trailerLength
+	if offset < 0 {
+		offset = 0
 	}
+	trailer := make([]byte, info.Size()-offset)
+	_, err = file.ReadAt(trailer, offset)
+	return trailer, err
 }
 
 /*
-SearchImage search in HTML page all the link that respect the pattern expected for downloading a comic page
- */
-func SearchImage(provider, title, url string) (imageURL string) {
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("cache-control", "no-cache")
-	res, err := http.DefaultClient.Do(req)
+downloadPage resolve the image of a page, download it and register it in the manifest of the chapter. Nothing is
+done if the page is already there from a previous attempt
+*/
+func downloadPage(ctx context.Context, staging *manifest, provider Provider, page int, pageURL string) error {
+	if staging.verified(page, pageURL) {
+		return nil
+	}
+	imageURL, err := provider.PageImage(ctx, pageURL)
 	if err != nil {
-		// handle error
-		log.Fatal(err)
+		return err
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		log.Fatalf("status code error while trying to extract images from %s: %d %s", url, res.StatusCode, res.Status)
+	fileName, err := DownloadImage(ctx, provider, staging.dir, page, imageURL)
+	if err != nil {
+		return err
+	}
+	return staging.add(page, pageURL, imageURL, fileName)
+}
+
+/*
+downloadChapter download the pages of a chapter which are not already in its staging directory, and send how many
+pages the chapter has
+*/
+func downloadChapter(ctx context.Context, staging *manifest, provider Provider, title string, chapter chapterid.ID, displayProgressBar bool) (count int, err error) {
+	if displayProgressBar {
+		fmt.Printf("search pages to download ... ")
 	}
-	body, _ := ioutil.ReadAll(res.Body)
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(body)))
+	imgURL, err := provider.Pages(ctx, title, chapter)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
-	//r, _ := regexp.Compile(fmt.Sprintf("^http.*://.+\\.%s/%s/\\d+/.+\\..+$", provider, title))
-	doc.Find("img").Each(func(i int, s *goquery.Selection) {
-		v, _ := s.Attr("src")
-		if strings.HasPrefix(v,"http") {
-			imageURL = v
-		} else {
-			imageURL = fmt.Sprintf("https:%s",v)
-		}
+	count = len(imgURL)
+	if err = staging.keep(count); err != nil {
 		return
-	//	v, _ := s.Attr("src")
-	//	if r.MatchString(v) {
-	//		log.Println("matches")
-	//		imageURL = v
-	//		return
-	//	}
+	}
+	var bar *progressbar.ProgressBar
+	if displayProgressBar {
+		fmt.Printf("done (found %d pages for %s chapter %s)\n", count, title, chapter)
+		if len(staging.Pages) > 0 {
+			fmt.Printf("resume the download, %d pages were already downloaded\n", len(staging.Pages))
+		}
+		// and then search for images to download
+		fmt.Println("download pages ...")
+		bar = progressbar.NewOptions(count)
+		bar.RenderBlank()
+	}
+	retriesBefore := Retries()
+	err = runPool(ctx, count, func(page int) error {
+		err := downloadPage(ctx, staging, provider, page, imgURL[page])
+		if bar != nil {
+			bar.Add(1)
+		}
+		return err
 	})
+	if retried := Retries() - retriesBefore; retried > 0 && displayProgressBar {
+		fmt.Printf("\n%d requests had to be sent again", retried)
+	}
 	return
 }
 
 /*
-SearchPages will send the number of page to download, and a list of url for every image to download
- */
-func SearchPages(provider, title string, chapter int) (count int, imagesURL []string) {
-	url := fmt.Sprintf("https://www.%s/%s/%d/%d", provider, title, chapter, 1)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("cache-control", "no-cache")
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		// handle error
-		log.Fatal(err)
+Manga download a chapter of a manga in its staging directory and create its archive. If something goes wrong, or if
+the context is cancelled, the error is returned so that the caller can decide to continue with the next chapter, and
+the pages already downloaded are kept in the staging directory: the next attempt will resume from there. The file
+written for the chapter is described in the archive sent back
+*/
+func Manga(ctx context.Context, provider Provider, title string, chapter chapterid.ID, outputPath string, displayProgressBar bool, format output.Format, metadata Metadata, processing imaging.Options) (nextChapter chapterid.ID, archive Archive, err error) {
+	// the chapter is part of the paths of the staging directory and of the archive
+	if err = chapter.Validate(); err != nil {
+		return chapter, archive, &ParseError{Err: err}
 	}
-	defer res.Body.Close()
-	if res.StatusCode != 200 {
-		log.Fatalf("status code error while trying to extract images from %s: %d %s", url, res.StatusCode, res.Status)
+	downloadPath := stagingPath(outputPath, title, chapter)
+	cbzPath := fmt.Sprintf("%s/%s", outputPath, title)
+	// check if the download dir exist, and if not create it
+	if err = os.MkdirAll(downloadPath, os.ModePerm); err != nil {
+		return chapter, archive, &FileError{Path: downloadPath, Err: err}
+	}
+	staging := loadManifest(downloadPath, provider.Name(), title, chapter)
+	count, err := downloadChapter(ctx, staging, provider, title, chapter, displayProgressBar)
+	if err == nil {
+		// the archive is only created for a complete chapter
+		pages, pagesURL, imagesURL := staging.files(count)
+		if err = ctx.Err(); err == nil && processing.Enabled() {
+			pages, pagesURL, imagesURL, err = processPages(downloadPath, pages, pagesURL, imagesURL, processing, displayProgressBar)
+		}
+		var fileName string
+		if err == nil {
+			fileName, err = CreateArchive(format, cbzPath, downloadPath, title, chapter, output.Book{
+				Series:   metadata.Title,
+				Author:   metadata.Author,
+				Summary:  metadata.Summary,
+				Language: metadata.Language,
+				Provider: provider.Name(),
+				Chapters: []output.Chapter{{
+					Number:    chapter,
+					Title:     fmt.Sprintf("Chapter %s", chapter),
+					URL:       provider.ChapterURL(title, chapter),
+					Pages:     pages,
+					PagesURL:  pagesURL,
+					ImagesURL: imagesURL,
+				}},
+			})
+		}
+		if err == nil {
+			archive, err = DescribeArchive(fileName, len(pages))
+		}
 	}
-	body, _ := ioutil.ReadAll(res.Body)
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(body)))
 	if err != nil {
-		log.Fatal(err)
-	}
-	// count how many images
-	count = 0
-	doc.Find("option").Each(func(i int, s *goquery.Selection) {
-		v, _ := s.Attr("value")
-		imagesURL = append(imagesURL, fmt.Sprintf("https://www.%s%s", provider, v))
-		count = count + 1
-	})
+		return chapter, archive, err
+	}
+	// remove the staging root too, if this was the last chapter in it
+	os.Remove(filepath.Dir(downloadPath))
+	nextChapter = chapter.Next()
 	return
 }
 
-func downloadChapter(path, provider, title string, chapter int, displayProgressBar bool) {
+/*
+processPages prepare the pages downloaded for the device before they are archived, in a sub directory of the staging
+directory. The urls follow the pages they come from
+*/
+func processPages(downloadPath string, files, pagesURL, imagesURL []string, processing imaging.Options, displayProgressBar bool) (pages, newPagesURL, newImagesURL []string, err error) {
 	if displayProgressBar {
-		fmt.Printf("search pages to download ... ")
+		fmt.Printf("\nprocess pages ... ")
+	}
+	processed, err := imaging.Pages(files, filepath.Join(downloadPath, processedDir), processing)
+	if e, ok := err.(*imaging.Error); ok {
+		return nil, nil, nil, &ImageError{URL: e.File, Err: e.Err}
+	}
+	if err != nil {
+		return nil, nil, nil, &ImageError{URL: downloadPath, Err: err}
+	}
+	kept := make(map[int]bool)
+	undecoded := 0
+	for _, page := range processed {
+		if page.Undecoded {
+			undecoded++
+		}
+		pages = append(pages, page.File)
+		newPagesURL = append(newPagesURL, pagesURL[page.Source])
+		newImagesURL = append(newImagesURL, imagesURL[page.Source])
+		kept[page.Source] = true
 	}
-	count, imgURL := SearchPages(provider, title, chapter)
 	if displayProgressBar {
-		fmt.Printf("done (found %d pages for %s chapter %d)\n", count, title, chapter)
-		// and then search for images to download
-		fmt.Println("download pages ...")
-		bar := progressbar.NewOptions(count)
-		bar.RenderBlank()
-		var wg sync.WaitGroup
-		wg.Add(len(imgURL))
-		for p, img := range imgURL {
-			go func(page int, urlImg string) {
-				DownloadImage(path, page, SearchImage(provider, title, urlImg))
-				bar.Add(1)
-				wg.Done()
-			}(p, img)
+		fmt.Printf("done")
+		if dropped := len(files) - len(kept); dropped > 0 {
+			fmt.Printf(" (%d pages of the blocklist dropped)", dropped)
 		}
-		wg.Wait()
-	} else {
-		for p, img := range imgURL {
-			DownloadImage(path, p, SearchImage(provider, title, img))
+		if undecoded > 0 {
+			fmt.Printf("\n  > warning: %d pages could not be decoded, they are archived as they were downloaded", undecoded)
 		}
-
 	}
 	return
 }
 
 /*
-Manga download a manga
+NewChapters send the chapters available for a manga from the given one, in reading order. The whole chapter list of
+the manga is read, so that a missing chapter does not hide the next ones
 */
-func Manga(provider, title string, chapter int, outputPath string, displayProgressBar bool) (nextChapter int) {
-	// check if download path exists
-	downloadPath := fmt.Sprintf("%s/%s/%03d", outputPath, title, chapter)
-	cbzPath := fmt.Sprintf("%s/%s", outputPath, title)
-	// check if the download dir exist, and if not create it
-	if _, err := os.Stat(downloadPath); os.IsNotExist(err) {
-		os.MkdirAll(downloadPath, os.ModePerm)
-	}
-	src, err := os.Stat(downloadPath)
+func NewChapters(ctx context.Context, provider Provider, title string, chapter chapterid.ID) ([]chapterid.ID, error) {
+	chapters, err := provider.Chapters(ctx, title)
 	if err != nil {
-		// still does not exists? then something wrong, exit in panic mode.
-		panic(err)
-	}
-	// check if the source is indeed a directory or not
-	if !src.IsDir() {
-		fmt.Println("source path is not a directory")
-		os.Exit(1)
-	} else {
-		// it's a directory, then erase the content before
-		dir, _ := ioutil.ReadDir(downloadPath)
-		for _, d := range dir {
-			os.RemoveAll(path.Join([]string{downloadPath, d.Name()}...))
-		}
-	}
-	downloadChapter(downloadPath, provider, title, chapter, displayProgressBar)
-	CreateCBZ(cbzPath, downloadPath, title, chapter)
-	nextChapter = chapter + 1
-	return
+		return nil, err
+	}
+	var newChapters []chapterid.ID
+	for _, available := range chapters {
+		if !available.Less(chapter) {
+			newChapters = append(newChapters, available)
+		}
+	}
+	return newChapters, nil
 }
 
 /*
 NextChapter check if a new chapter exists, return true if exists and false otherwise
 */
-func NextChapter(provider, title string, chapter int) bool {
-	count, _ := SearchPages(provider, title, chapter)
-	return (count > 0)
+func NextChapter(ctx context.Context, provider Provider, title string, chapter chapterid.ID) bool {
+	chapters, err := NewChapters(ctx, provider, title, chapter)
+	return err == nil && len(chapters) > 0
 }
@@ -0,0 +1,176 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ProviderOptions override the defaults for the requests sent to one provider
+type ProviderOptions struct {
+	Retry     *RetryPolicy `json:"retry,omitempty"`
+	RateLimit *RateLimit   `json:"rateLimit,omitempty"`
+}
+
+// client send the requests for one provider, with the options configured for it. There is only one client per
+// provider, so that its rate limit is shared by all the downloads
+type client struct {
+	retry   RetryPolicy
+	limiter *limiter
+}
+
+var (
+	clientsLock     sync.Mutex
+	clients         = make(map[string]*client)
+	defaultRetry    = DefaultRetryPolicy()
+	defaultRate     = DefaultRateLimit()
+	providerOptions = make(map[string]ProviderOptions)
+)
+
+/*
+SetRetryPolicy change the retry policy used for the providers that don't have their own
+*/
+func SetRetryPolicy(policy RetryPolicy) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	defaultRetry = policy
+	clients = make(map[string]*client)
+}
+
+/*
+SetRateLimit change the rate limit used for the providers that don't have their own
+*/
+func SetRateLimit(rate RateLimit) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	defaultRate = rate
+	clients = make(map[string]*client)
+}
+
+/*
+ConfigureProvider set the options of a provider, they are used for all the requests sent to it from now on
+*/
+func ConfigureProvider(name string, options ProviderOptions) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	providerOptions[name] = options
+	delete(clients, name)
+}
+
+/*
+clientFor send the client of a provider, created the first time with the options of the provider
+*/
+func clientFor(name string) *client {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	if c, ok := clients[name]; ok {
+		return c
+	}
+	c := &client{retry: defaultRetry}
+	rate := defaultRate
+	if options, ok := providerOptions[name]; ok {
+		if options.Retry != nil {
+			c.retry = *options.Retry
+		}
+		if options.RateLimit != nil {
+			rate = *options.RateLimit
+		}
+	}
+	c.limiter = newLimiter(rate)
+	clients[name] = c
+	return c
+}
+
+/*
+get send a GET request to a site, the caller must close the body of the response if there is no error
+*/
+func (c *client) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, &NetworkError{URL: url, Err: err}
+	}
+	req.Header.Add("cache-control", "no-cache")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &NetworkError{URL: url, Err: err}
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, &StatusError{
+			URL:        url,
+			StatusCode: res.StatusCode,
+			Status:     res.Status,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+	return res, nil
+}
+
+/*
+do send a GET request and give the response to read, the whole thing is attempted again as long as the
+retry policy allows it, so a failure while reading the body is retried as well. Nothing is attempted anymore once
+the context is cancelled
+*/
+func (c *client) do(ctx context.Context, url string, read func(res *http.Response) error) (err error) {
+	for attempt := 1; ; attempt++ {
+		var res *http.Response
+		var release func()
+		if release, err = acquireHost(ctx, url); err != nil {
+			return
+		}
+		if err = c.limiter.wait(ctx); err == nil {
+			if res, err = c.get(ctx, url); err == nil {
+				err = read(res)
+				res.Body.Close()
+			}
+		}
+		release()
+		if err == nil || ctx.Err() != nil || attempt >= c.retry.MaxAttempts || !c.retry.retryable(err) {
+			return
+		}
+		atomic.AddInt64(&retries, 1)
+		if err = sleep(ctx, c.retry.delay(attempt, err)); err != nil {
+			return
+		}
+	}
+}
+
+/*
+sleep wait for the given duration, or less if the context is cancelled in the meantime
+*/
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+getDocument download an HTML page and parse it so that the providers can search in it
+*/
+func (c *client) getDocument(ctx context.Context, url string) (doc *goquery.Document, err error) {
+	err = c.do(ctx, url, func(res *http.Response) error {
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return &NetworkError{URL: url, Err: err}
+		}
+		if doc, err = readDocument(bytes.NewReader(body)); err != nil {
+			return &ParseError{URL: url, Err: err}
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,179 @@
+package fetch
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	// the decoders of the formats used by the sites, to check the pages are valid images
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+const (
+	// sniffLength is the number of bytes read to find the format of an image, like http.DetectContentType does
+	sniffLength = 512
+	// trailerLength is the number of bytes read at the end of an image to check it is complete
+	trailerLength = 32
+)
+
+// imageFormat is an image format the sites use for the pages, recognized by the first bytes of the file
+type imageFormat struct {
+	extension string
+	mimeType  string
+	match     func(header []byte) bool
+}
+
+var imageFormats = []imageFormat{
+	{".jpg", "image/jpeg", func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte{0xff, 0xd8, 0xff})
+	}},
+	{".png", "image/png", func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n"))
+	}},
+	{".gif", "image/gif", func(header []byte) bool {
+		return bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a"))
+	}},
+	{".webp", "image/webp", func(header []byte) bool {
+		return len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP"))
+	}},
+}
+
+// ImageError is returned when a site sends something else than the image of a page, like an HTML error page, or
+// when a downloaded page could not be prepared for the device. URL is then the file of the page
+type ImageError struct {
+	URL string
+	Err error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("invalid image %s: %s", e.URL, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+/*
+sniffImage find the format of an image from its first bytes. The Content-Type sent by the site is only trusted when
+the bytes are not enough to tell, and the HTML or text pages some sites send instead of the image are rejected
+*/
+func sniffImage(header []byte, contentType string) (imageFormat, error) {
+	if len(header) == 0 {
+		return imageFormat{}, fmt.Errorf("empty file")
+	}
+	for _, format := range imageFormats {
+		if format.match(header) {
+			return format, nil
+		}
+	}
+	sniffed := http.DetectContentType(header)
+	if strings.HasPrefix(sniffed, "text/") {
+		return imageFormat{}, fmt.Errorf("the site sent %s instead of an image", strings.Split(sniffed, ";")[0])
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		for _, format := range imageFormats {
+			if format.mimeType == mediaType {
+				return format, nil
+			}
+		}
+	}
+	return imageFormat{}, fmt.Errorf("unknown image format (%s)", contentType)
+}
+
+/*
+complete check the end of an image, to detect the ones which were cut during the download: the JPEG, PNG and GIF
+files have a marker at their end, and the WebP files tell their size at their start
+*/
+func (f imageFormat) complete(header, trailer []byte, size int64) bool {
+	switch f.extension {
+	case ".jpg":
+		// some encoders add a few bytes of padding after the end of image marker
+		return bytes.Contains(trailer, []byte{0xff, 0xd9})
+	case ".png":
+		return bytes.Contains(trailer, []byte("IEND"))
+	case ".gif":
+		return bytes.HasSuffix(bytes.TrimRight(trailer, "\x00"), []byte{0x3b})
+	case ".webp":
+		declared := int64(header[4]) | int64(header[5])<<8 | int64(header[6])<<16 | int64(header[7])<<24
+		return size >= declared+8
+	}
+	return true
+}
+
+/*
+validate decode the whole image, so that a page which can't be displayed is detected before its chapter is
+archived. The standard library has no WebP decoder, for these ones only the header is checked
+*/
+func (f imageFormat) validate(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if f.extension == ".webp" {
+		return validateWebP(file)
+	}
+	decoded, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+	if bounds := decoded.Bounds(); bounds.Empty() {
+		return fmt.Errorf("empty image")
+	}
+	return nil
+}
+
+/*
+validateWebP check the first chunk of a WebP file is one of the image chunks
+*/
+func validateWebP(file io.Reader) error {
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return err
+	}
+	switch string(header[12:16]) {
+	case "VP8 ", "VP8L", "VP8X":
+		return nil
+	}
+	return fmt.Errorf("unknown webp chunk %q", header[12:16])
+}
+
+/*
+validateFile run all the checks on an image already on disk, to verify the pages kept from a previous attempt
+*/
+func validateFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, header)
+	file.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	header = header[:n]
+	format, err := sniffImage(header, "")
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+	trailer, err := readTrailer(fileName)
+	if err != nil {
+		return err
+	}
+	if !format.complete(header, trailer, info.Size()) {
+		return fmt.Errorf("truncated image")
+	}
+	return format.validate(fileName)
+}
@@ -0,0 +1,93 @@
+package fetch
+
+import (
+	"context"
+	"net/url"
+	"sync"
+)
+
+var (
+	jobs       = 4
+	hostJobs   = 2
+	hostsLock  sync.Mutex
+	hostsSlots = make(map[string]chan struct{})
+)
+
+/*
+SetJobs change how many pages are downloaded at the same time, and how many requests can be sent at the same time
+to one host. A value lower than 1 keeps the current one
+*/
+func SetJobs(pages, perHost int) {
+	hostsLock.Lock()
+	defer hostsLock.Unlock()
+	if pages > 0 {
+		jobs = pages
+	}
+	if perHost > 0 {
+		hostJobs = perHost
+		hostsSlots = make(map[string]chan struct{})
+	}
+}
+
+/*
+runPool run the task for every index from 0 to count-1, with at most jobs tasks running at the same time. All the
+tasks are run even if one fails, the first error is sent back. No more task is started once the context is cancelled
+*/
+func runPool(ctx context.Context, count int, task func(i int) error) error {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	workers := jobs
+	if workers > count {
+		workers = count
+	}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := task(i); err != nil {
+					once.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := 0; i < count; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr == nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+/*
+acquireHost wait for a free slot to send a request to the host of the url, the function returned frees it
+*/
+func acquireHost(ctx context.Context, rawURL string) (release func(), err error) {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+	hostsLock.Lock()
+	slots, ok := hostsSlots[host]
+	if !ok {
+		slots = make(chan struct{}, hostJobs)
+		hostsSlots[host] = slots
+	}
+	hostsLock.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,95 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+)
+
+// Provider is the adapter for one manga site: it knows how to list the chapters of a manga, the pages of a
+// chapter, where the image of a page is, and how to describe the manga itself. Every request stops when the context is
+// cancelled
+type Provider interface {
+	// Name send the name of the provider in the registry, used to find its options
+	Name() string
+	// Chapters send the list of the chapters available for a manga, in reading order
+	Chapters(ctx context.Context, title string) ([]chapterid.ID, error)
+	// ChapterURL send the url where a chapter can be read on the site
+	ChapterURL(title string, chapter chapterid.ID) string
+	// Pages send the url of every page of a chapter, in reading order
+	Pages(ctx context.Context, title string, chapter chapterid.ID) ([]string, error)
+	// PageImage resolve the url of the image displayed on a page
+	PageImage(ctx context.Context, pageURL string) (string, error)
+	// Metadata send the informations the site gives about a manga
+	Metadata(ctx context.Context, title string) (Metadata, error)
+}
+
+// Metadata is what a provider knows about a manga, used to describe the archives we are creating
+type Metadata struct {
+	Title    string
+	Author   string
+	Summary  string
+	Language string
+	URL      string
+}
+
+var providers = make(map[string]Provider)
+
+/*
+RegisterProvider add a provider to the registry, under the name used by the -provider flag and stored in the history
+*/
+func RegisterProvider(name string, provider Provider) {
+	providers[name] = provider
+}
+
+/*
+GetProvider search the registry for a provider, and return an error listing the supported ones if it does not exist
+*/
+func GetProvider(name string) (Provider, error) {
+	provider, ok := providers[name]
+	if !ok {
+		return nil, &ParseError{Err: fmt.Errorf("unknown provider <%s>, supported providers are: %s", name, strings.Join(ProviderNames(), ", "))}
+	}
+	return provider, nil
+}
+
+/*
+ProviderNames send the sorted list of the names of all the registered providers
+*/
+func ProviderNames() (names []string) {
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+/*
+ProviderForURL search the provider a page comes from, by the site of its url. The providers are usually named after
+their site, so the name is accepted as well as the site of the chapters
+*/
+func ProviderForURL(link string) (string, bool) {
+	host := siteOf(link)
+	if host == "" {
+		return "", false
+	}
+	for _, name := range ProviderNames() {
+		if siteOf(providers[name].ChapterURL("", chapterid.FromInt(1))) == host || strings.ToLower(name) == host {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// siteOf send the host of an url, without the www
+func siteOf(link string) string {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
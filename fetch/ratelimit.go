@@ -0,0 +1,80 @@
+package fetch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket shared by all the requests sent to a provider: RequestsPerSecond tokens are added every
+// second, up to Burst, and every request takes one. MinDelay is the minimum number of milliseconds between two
+// requests, like the crawl-delay of a robots.txt. A RequestsPerSecond of 0 means no limit
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+	MinDelay          int     `json:"minDelay"`
+}
+
+// limiter apply a rate limit, the requests wait for their turn one after the other
+type limiter struct {
+	lock        sync.Mutex
+	rate        RateLimit
+	tokens      float64
+	last        time.Time
+	lastRequest time.Time
+}
+
+/*
+DefaultRateLimit send the rate limit used when nothing is configured: 2 requests per second, with bursts of 4
+*/
+func DefaultRateLimit() RateLimit {
+	return RateLimit{
+		RequestsPerSecond: 2,
+		Burst:             4,
+	}
+}
+
+func newLimiter(rate RateLimit) *limiter {
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return &limiter{
+		rate:   rate,
+		tokens: float64(rate.Burst),
+		last:   time.Now(),
+	}
+}
+
+/*
+wait block until the next request can be sent, or until the context is cancelled
+*/
+func (l *limiter) wait(ctx context.Context) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.rate.RequestsPerSecond > 0 {
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate.RequestsPerSecond
+		if l.tokens > float64(l.rate.Burst) {
+			l.tokens = float64(l.rate.Burst)
+		}
+		l.last = now
+		if l.tokens < 1 {
+			// not enough tokens, wait for the missing part of one
+			if err := sleep(ctx, time.Duration((1-l.tokens)/l.rate.RequestsPerSecond*float64(time.Second))); err != nil {
+				return err
+			}
+			l.tokens = 1
+			l.last = time.Now()
+		}
+		l.tokens--
+	}
+	if l.rate.MinDelay > 0 {
+		if elapsed := time.Since(l.lastRequest); elapsed < time.Duration(l.rate.MinDelay)*time.Millisecond {
+			if err := sleep(ctx, time.Duration(l.rate.MinDelay)*time.Millisecond-elapsed); err != nil {
+				return err
+			}
+		}
+	}
+	l.lastRequest = time.Now()
+	return nil
+}
@@ -0,0 +1,104 @@
+package fetch
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RetryPolicy tells how many times, and how patiently, a failing request is sent again. Delays are in milliseconds,
+// the delay before the attempt n+1 is BaseDelay * 2^(n-1), capped to MaxDelay, with a random part of Jitter percent
+type RetryPolicy struct {
+	MaxAttempts     int   `json:"maxAttempts"`
+	BaseDelay       int   `json:"baseDelay"`
+	MaxDelay        int   `json:"maxDelay"`
+	Jitter          int   `json:"jitter"`
+	RetryableStatus []int `json:"retryableStatus"`
+}
+
+var retries int64
+
+/*
+DefaultRetryPolicy send the retry policy used when nothing is configured: 4 attempts, starting with half a second
+*/
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   500,
+		MaxDelay:    30000,
+		Jitter:      50,
+		RetryableStatus: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+/*
+Retries send the number of requests that have been sent again since the start, used to report it with the progress
+*/
+func Retries() int64 {
+	return atomic.LoadInt64(&retries)
+}
+
+/*
+retryable check if an error is worth another attempt: the network errors and the invalid images always are, and the
+HTTP errors only when their status code is in the policy. When the site asks to wait longer than MaxDelay with a
+Retry-After header, we give up instead of stalling the download
+*/
+func (p RetryPolicy) retryable(err error) bool {
+	switch e := err.(type) {
+	case *NetworkError, *ImageError:
+		return true
+	case *StatusError:
+		if p.MaxDelay > 0 && e.RetryAfter > time.Duration(p.MaxDelay)*time.Millisecond {
+			return false
+		}
+		for _, status := range p.RetryableStatus {
+			if status == e.StatusCode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+delay compute how long to wait before the next attempt, the site can ask for more with a Retry-After header. There
+is no need to cap it to MaxDelay, retryable already gave up when it asks for more
+*/
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	backoff := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && backoff > float64(p.MaxDelay) {
+		backoff = float64(p.MaxDelay)
+	}
+	backoff += backoff * float64(p.Jitter) / 100 * (rand.Float64()*2 - 1)
+	wait := time.Duration(backoff) * time.Millisecond
+	if e, ok := err.(*StatusError); ok && e.RetryAfter > wait {
+		wait = e.RetryAfter
+	}
+	return wait
+}
+
+/*
+parseRetryAfter read the Retry-After header, which is either a number of seconds or a date
+*/
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,71 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	BaseDelay:       1,
+	MaxDelay:        1000,
+	RetryableStatus: []int{http.StatusServiceUnavailable},
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network", &NetworkError{URL: "u", Err: errors.New("reset")}, true},
+		{"image", &ImageError{URL: "u", Err: errors.New("truncated image")}, true},
+		{"retryable status", &StatusError{URL: "u", StatusCode: http.StatusServiceUnavailable}, true},
+		{"other status", &StatusError{URL: "u", StatusCode: http.StatusNotFound}, false},
+		{"short retry-after", &StatusError{URL: "u", StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Second}, true},
+		{"long retry-after", &StatusError{URL: "u", StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Hour}, false},
+		{"other error", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testPolicy.retryable(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelayHonorsRetryAfter(t *testing.T) {
+	err := &StatusError{URL: "u", StatusCode: http.StatusServiceUnavailable, RetryAfter: 500 * time.Millisecond}
+	if got := testPolicy.delay(1, err); got != 500*time.Millisecond {
+		t.Errorf("got %s, want %s", got, 500*time.Millisecond)
+	}
+}
+
+func TestGiveUpOnLongRetryAfter(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := &client{retry: testPolicy, limiter: newLimiter(RateLimit{})}
+	err := c.do(context.Background(), server.URL, func(res *http.Response) error { return nil })
+	statusErr, ok := err.(*StatusError)
+	if !ok {
+		t.Fatalf("got %v, want a StatusError", err)
+	}
+	if statusErr.RetryAfter != time.Hour {
+		t.Errorf("got a Retry-After of %s, want %s", statusErr.RetryAfter, time.Hour)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,279 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+)
+
+// Rules describe how to scrap a manga site, so that a new site can be added by writing a rules file instead of code
+type Rules struct {
+	Name       string   `json:"name"`
+	BaseURL    string   `json:"baseURL"`
+	IndexURL   string   `json:"indexURL"`
+	ChapterURL string   `json:"chapterURL"`
+	Language   string   `json:"language"`
+	Chapters   Selector `json:"chapters"`
+	Pages      Selector `json:"pages"`
+	Image      Selector `json:"image"`
+	Title      Selector `json:"title"`
+	Author     Selector `json:"author"`
+	Summary    Selector `json:"summary"`
+}
+
+// Selector is a CSS selector, with the attribute to read on the selected elements (the text is used if no attribute
+// is set) and an optional regular expression whose first group extracts the value
+type Selector struct {
+	CSS       string `json:"css"`
+	Attribute string `json:"attribute,omitempty"`
+	Pattern   string `json:"pattern,omitempty"`
+	Last      bool   `json:"last,omitempty"`
+}
+
+// rulesProvider is the generic provider, driven by the rules of a site
+type rulesProvider struct {
+	rules Rules
+}
+
+func init() {
+	for _, rules := range DefaultRules() {
+		RegisterRules(rules)
+	}
+}
+
+/*
+DefaultRules send the rules for the sites supported out of the box, they are the ones written in the rules file the
+first time we are launched
+*/
+func DefaultRules() []Rules {
+	var rules []Rules
+	for _, host := range []string{"mangareader.net", "mangapanda.com"} {
+		rules = append(rules, Rules{
+			Name:       host,
+			BaseURL:    fmt.Sprintf("https://www.%s", host),
+			IndexURL:   "{base}/{title}",
+			ChapterURL: "{base}/{title}/{chapter}/1",
+			Language:   "en",
+			Chapters:   Selector{CSS: "#listing a", Attribute: "href", Pattern: `/(\d+(?:\.\d+)?)$`},
+			Pages:      Selector{CSS: "option", Attribute: "value"},
+			Image:      Selector{CSS: "img", Attribute: "src", Last: true},
+			Title:      Selector{CSS: ".aname"},
+			Author:     Selector{CSS: "#mangaproperties td:contains('Author') + td"},
+			Summary:    Selector{CSS: "#readmangasum p"},
+		})
+	}
+	return rules
+}
+
+/*
+RegisterRules create the generic provider for a site and register it, replacing a provider with the same name
+*/
+func RegisterRules(rules Rules) {
+	RegisterProvider(rules.Name, &rulesProvider{rules: rules})
+}
+
+/*
+Name send the name of the site in the rules
+*/
+func (p *rulesProvider) Name() string {
+	return p.rules.Name
+}
+
+func (p *rulesProvider) url(template, title string, chapter chapterid.ID) string {
+	return strings.NewReplacer(
+		"{base}", p.rules.BaseURL,
+		"{title}", title,
+		"{chapter}", chapter.String(),
+	).Replace(template)
+}
+
+/*
+resolve turn a link found in a page into an absolute url, the sites like to use relative or protocol-relative links
+*/
+func (p *rulesProvider) resolve(link string) string {
+	base, err := url.Parse(p.rules.BaseURL)
+	if err != nil {
+		return link
+	}
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
+}
+
+/*
+Chapters read the chapter list from the index page of the manga
+*/
+func (p *rulesProvider) Chapters(ctx context.Context, title string) ([]chapterid.ID, error) {
+	url := p.url(p.rules.IndexURL, title, "")
+	doc, err := clientFor(p.rules.Name).getDocument(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	chapters, err := p.parseChapters(doc)
+	if err != nil {
+		return nil, &ParseError{URL: url, Err: err}
+	}
+	return chapters, nil
+}
+
+func (p *rulesProvider) parseChapters(doc *goquery.Document) (chapters []chapterid.ID, err error) {
+	values, err := p.rules.Chapters.all(doc)
+	if err != nil {
+		return
+	}
+	// the sites often list the last chapters first, and some of them twice
+	seen := make(map[chapterid.ID]bool)
+	for _, v := range values {
+		if chapter, err := chapterid.Parse(v); err == nil && !seen[chapter] {
+			seen[chapter] = true
+			chapters = append(chapters, chapter)
+		}
+	}
+	chapterid.Sort(chapters)
+	return
+}
+
+/*
+ChapterURL send the url of the first page of a chapter
+*/
+func (p *rulesProvider) ChapterURL(title string, chapter chapterid.ID) string {
+	return p.url(p.rules.ChapterURL, title, chapter)
+}
+
+/*
+Pages send a list of url for every page to download, extracted from the first page of the chapter
+*/
+func (p *rulesProvider) Pages(ctx context.Context, title string, chapter chapterid.ID) ([]string, error) {
+	url := p.ChapterURL(title, chapter)
+	doc, err := clientFor(p.rules.Name).getDocument(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	pagesURL, err := p.parsePages(doc)
+	if err != nil {
+		return nil, &ParseError{URL: url, Err: err}
+	}
+	return pagesURL, nil
+}
+
+func (p *rulesProvider) parsePages(doc *goquery.Document) (pagesURL []string, err error) {
+	values, err := p.rules.Pages.all(doc)
+	if err != nil {
+		return
+	}
+	for _, v := range values {
+		pagesURL = append(pagesURL, p.resolve(v))
+	}
+	return
+}
+
+/*
+PageImage search in HTML page the link of the comic page to download
+*/
+func (p *rulesProvider) PageImage(ctx context.Context, pageURL string) (string, error) {
+	doc, err := clientFor(p.rules.Name).getDocument(ctx, pageURL)
+	if err != nil {
+		return "", err
+	}
+	imageURL, err := p.parseImage(doc)
+	if err != nil {
+		return "", &ParseError{URL: pageURL, Err: err}
+	}
+	return imageURL, nil
+}
+
+func (p *rulesProvider) parseImage(doc *goquery.Document) (string, error) {
+	v, err := p.rules.Image.one(doc)
+	if err != nil {
+		return "", err
+	}
+	if v == "" {
+		return "", fmt.Errorf("no image matching '%s' found", p.rules.Image.CSS)
+	}
+	return p.resolve(v), nil
+}
+
+/*
+Metadata read the name, author and summary of the manga from its index page
+*/
+func (p *rulesProvider) Metadata(ctx context.Context, title string) (Metadata, error) {
+	url := p.url(p.rules.IndexURL, title, "")
+	doc, err := clientFor(p.rules.Name).getDocument(ctx, url)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return p.parseMetadata(doc, title, url), nil
+}
+
+func (p *rulesProvider) parseMetadata(doc *goquery.Document, title, url string) (metadata Metadata) {
+	metadata = Metadata{
+		Title:    title,
+		Language: p.rules.Language,
+		URL:      url,
+	}
+	if v, err := p.rules.Title.one(doc); err == nil && v != "" {
+		metadata.Title = v
+	}
+	metadata.Author, _ = p.rules.Author.one(doc)
+	metadata.Summary, _ = p.rules.Summary.one(doc)
+	return
+}
+
+/*
+readDocument parse an HTML page, the providers only works on parsed documents so that they can be fed with saved pages
+*/
+func readDocument(r io.Reader) (*goquery.Document, error) {
+	return goquery.NewDocumentFromReader(r)
+}
+
+/*
+all send the values of every element matching the selector
+*/
+func (s Selector) all(doc *goquery.Document) (values []string, err error) {
+	if s.CSS == "" {
+		return
+	}
+	var pattern *regexp.Regexp
+	if s.Pattern != "" {
+		if pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return
+		}
+	}
+	doc.Find(s.CSS).Each(func(i int, sel *goquery.Selection) {
+		v := strings.TrimSpace(sel.Text())
+		if s.Attribute != "" {
+			v, _ = sel.Attr(s.Attribute)
+		}
+		if pattern != nil {
+			match := pattern.FindStringSubmatch(v)
+			if match == nil {
+				return
+			}
+			v = match[len(match)-1]
+		}
+		values = append(values, v)
+	})
+	return
+}
+
+/*
+one send the value of the first element matching the selector, or of the last one if asked by the rules
+*/
+func (s Selector) one(doc *goquery.Document) (string, error) {
+	values, err := s.all(doc)
+	if err != nil || len(values) == 0 {
+		return "", err
+	}
+	if s.Last {
+		return values[len(values)-1], nil
+	}
+	return values[0], nil
+}
@@ -0,0 +1,162 @@
+package fetch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+)
+
+// customRules describe a site unlike mangareader: the chapters are read from the text, and the pages are images
+var customRules = Rules{
+	Name:       "example.com",
+	BaseURL:    "https://www.example.com",
+	IndexURL:   "{base}/manga/{title}",
+	ChapterURL: "{base}/manga/{title}/{chapter}",
+	Language:   "en",
+	Chapters:   Selector{CSS: ".chapters .number", Pattern: `^Chapter (.+)$`},
+	Pages:      Selector{CSS: ".reader img", Attribute: "data-src"},
+	Image:      Selector{CSS: ".reader img", Attribute: "data-src"},
+	Title:      Selector{CSS: ".series"},
+}
+
+func readFixture(t *testing.T, name string) *goquery.Document {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	doc, err := readDocument(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func chapters(values ...string) []chapterid.ID {
+	var ids []chapterid.ID
+	for _, value := range values {
+		id, _ := chapterid.Parse(value)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestParseChapters(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		fixture string
+		want    []chapterid.ID
+	}{
+		{"mangareader", DefaultRules()[0], "mangareader-index.html", chapters("1", "2", "2.5", "3", "10")},
+		{"custom", customRules, "custom-index.html", chapters("11", "11.5", "12", "extra")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &rulesProvider{rules: tt.rules}
+			got, err := p.parseChapters(readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePages(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		fixture string
+		want    []string
+	}{
+		{"mangareader", DefaultRules()[0], "mangareader-chapter.html", []string{
+			"https://www.mangareader.net/btooom/1",
+			"https://www.mangareader.net/btooom/1/2",
+			"https://www.mangareader.net/btooom/1/3",
+		}},
+		{"custom", customRules, "custom-chapter.html", []string{
+			"https://www.example.com/pages/001.webp",
+			"https://www.example.com/pages/002.webp",
+			"https://cdn.example.com/solo-leveling/11/003.webp",
+		}},
+		{"no pages", customRules, "custom-index.html", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &rulesProvider{rules: tt.rules}
+			got, err := p.parsePages(readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		fixture string
+		want    string
+		wantErr bool
+	}{
+		{"mangareader first page", DefaultRules()[0], "mangareader-chapter.html", "https://i10.mangareader.net/btooom/1/btooom-1.jpg", false},
+		{"mangareader last image", DefaultRules()[0], "mangareader-page.html", "https://i10.mangareader.net/btooom/1/btooom-2.jpg", false},
+		{"custom first image", customRules, "custom-chapter.html", "https://www.example.com/pages/001.webp", false},
+		{"no image", customRules, "custom-index.html", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &rulesProvider{rules: tt.rules}
+			got, err := p.parseImage(readFixture(t, tt.fixture))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		fixture string
+		want    Metadata
+	}{
+		{"mangareader", DefaultRules()[0], "mangareader-index.html", Metadata{
+			Title:    "Btooom!",
+			Author:   "INOUE Junya",
+			Summary:  "Ryouta Sakamoto is a 22-year-old unemployed man who lives with his mother.",
+			Language: "en",
+			URL:      "https://www.mangareader.net/btooom",
+		}},
+		{"custom", customRules, "custom-index.html", Metadata{
+			Title:    "Solo Leveling",
+			Language: "en",
+			URL:      "https://www.example.com/manga/solo-leveling",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &rulesProvider{rules: tt.rules}
+			got := p.parseMetadata(readFixture(t, tt.fixture), "title", tt.want.URL)
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
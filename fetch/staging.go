@@ -0,0 +1,183 @@
+package fetch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+)
+
+const (
+	manifestName = "manifest.json"
+	// processedDir is the sub directory of the staging directory where the pages are prepared for the device
+	processedDir = "processed"
+)
+
+// manifest keep track of the pages of a chapter already downloaded in its staging directory, so that an interrupted
+// download can be resumed without downloading them again
+type manifest struct {
+	lock     sync.Mutex
+	dir      string
+	Provider string             `json:"provider"`
+	Title    string             `json:"title"`
+	Chapter  chapterid.ID       `json:"chapter"`
+	Pages    map[int]stagedPage `json:"pages"`
+}
+
+// stagedPage is a page downloaded in the staging directory, with what we need to verify it is still there and complete
+type stagedPage struct {
+	URL    string `json:"url"`
+	Image  string `json:"image"`
+	File   string `json:"file"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+/*
+stagingPath send the directory where the pages of a chapter are downloaded before being archived
+*/
+func stagingPath(outputPath, title string, chapter chapterid.ID) string {
+	return fmt.Sprintf("%s/%s/.staging/%s", outputPath, title, chapter.Padded())
+}
+
+/*
+loadManifest read the manifest of a staging directory, or start a new one if there is none or if it was written
+for another provider
+*/
+func loadManifest(dir, provider, title string, chapter chapterid.ID) *manifest {
+	m := &manifest{
+		dir:      dir,
+		Provider: provider,
+		Title:    title,
+		Chapter:  chapter,
+		Pages:    make(map[int]stagedPage),
+	}
+	var previous manifest
+	byteValue, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil || json.Unmarshal(byteValue, &previous) != nil {
+		return m
+	}
+	if previous.Provider == provider && previous.Title == title && previous.Chapter == chapter && previous.Pages != nil {
+		m.Pages = previous.Pages
+	}
+	return m
+}
+
+/*
+verified check if a page is already downloaded: it must be in the manifest, for the same url, and the file must be
+still on disk with the same size and hash, and be a valid image
+*/
+func (m *manifest) verified(page int, pageURL string) bool {
+	m.lock.Lock()
+	staged, ok := m.Pages[page]
+	m.lock.Unlock()
+	if !ok || staged.URL != pageURL {
+		return false
+	}
+	fileName := filepath.Join(m.dir, staged.File)
+	size, hash, err := hashFile(fileName)
+	return err == nil && size == staged.Size && hash == staged.SHA256 && validateFile(fileName) == nil
+}
+
+/*
+add register a downloaded page and write the manifest right away, so that it survives a crash
+*/
+func (m *manifest) add(page int, pageURL, imageURL, fileName string) error {
+	size, hash, err := hashFile(fileName)
+	if err != nil {
+		return &FileError{Path: fileName, Err: err}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Pages[page] = stagedPage{
+		URL:    pageURL,
+		Image:  imageURL,
+		File:   filepath.Base(fileName),
+		Size:   size,
+		SHA256: hash,
+	}
+	return m.save()
+}
+
+/*
+keep drop from the manifest the pages which are not in the chapter anymore, when an earlier attempt found more pages
+than the site lists now, and remove their files
+*/
+func (m *manifest) keep(count int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	dropped := false
+	for page, staged := range m.Pages {
+		if page < 0 || page >= count {
+			os.Remove(filepath.Join(m.dir, staged.File))
+			delete(m.Pages, page)
+			dropped = true
+		}
+	}
+	if !dropped {
+		return nil
+	}
+	return m.save()
+}
+
+/*
+files send the pages of the chapter in reading order, with the url of the pages and of the images they come from.
+Only the count pages of the chapter are sent, even if the manifest has more
+*/
+func (m *manifest) files(count int) (files, pagesURL, imagesURL []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var indexes []int
+	for page := range m.Pages {
+		if page >= 0 && page < count {
+			indexes = append(indexes, page)
+		}
+	}
+	sort.Ints(indexes)
+	for _, page := range indexes {
+		files = append(files, filepath.Join(m.dir, m.Pages[page].File))
+		pagesURL = append(pagesURL, m.Pages[page].URL)
+		imagesURL = append(imagesURL, m.Pages[page].Image)
+	}
+	return
+}
+
+/*
+save write the manifest in a temporary file renamed afterwards, a manifest is never half written
+*/
+func (m *manifest) save() error {
+	fileName := filepath.Join(m.dir, manifestName)
+	file, _ := json.MarshalIndent(m, "", " ")
+	if err := ioutil.WriteFile(fileName+".part", file, 0644); err != nil {
+		return &FileError{Path: fileName, Err: err}
+	}
+	if err := os.Rename(fileName+".part", fileName); err != nil {
+		return &FileError{Path: fileName, Err: err}
+	}
+	return nil
+}
+
+/*
+hashFile send the size and the SHA-256 of a file
+*/
+func hashFile(fileName string) (size int64, hash string, err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	h := sha256.New()
+	if size, err = io.Copy(h, file); err != nil {
+		return
+	}
+	hash = hex.EncodeToString(h.Sum(nil))
+	return
+}
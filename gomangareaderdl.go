@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/francoiscolombo/gomangareaderdl/commands"
+	"github.com/francoiscolombo/gomangareaderdl/fetch"
 	"github.com/francoiscolombo/gomangareaderdl/settings"
 )
 
@@ -20,13 +25,32 @@ type parameters struct {
 	Update   bool
 	Help     bool
 	Manga    string
-	Chapter  int
+	Chapter  string
+	Until    string
+	Max      int
 	Provider string
 	Path     string
 	Silent   bool
 	Force    bool
 	Output   string
-	Next     int
+	Next     string
+	Jobs     int
+	Format   string
+	Profile  string
+	Trim     string
+	Spread   string
+	Bundle   bool
+	Gaps     bool
+	Scan     bool
+	Repair   bool
+	From     string
+	To       string
+	Volume   int
+	Mapping  string
+	Remove   bool
+	Block    bool
+	Archive  string
+	Page     int
 }
 
 func usage() {
@@ -40,22 +64,54 @@ Commands list
  -config    Set defaults
  -update    Update subscribed manga
  -list      List downloaded manga
+ -bundle    Merge downloaded chapters into volumes
+ -block     Drop a page (credits, ads...) from the chapters downloaded from now on
+ -gaps      Search the chapters missing for the mangas of the history, like a 10.5 published after the 11
+ -scan      Rebuild the history from the cbz of the output path
 
 Options, Sub-commands
  -fetch
   -manga       Set manga to download
-  -chapter     Set start chapter to download
+  -chapter     Set start chapter to download, or the chapters to download: 100-120, 5,7,9
+  -until       Set last chapter to download
+  -max         Set how many chapters are downloaded at most
   -provider    Set download site (if not set, the default provider is used)
   -path        If used, allow to download manga to another path instead of the default one
   -force       Overwrite history
   -silent      Don't display download progress bar
+  -jobs        Set how many pages are downloaded at the same time (if not set, the default is used)
+  -format      Set the format of the downloaded chapters: cbz, epub, pdf, folder (if not set, the default format is used)
+  -profile     Set how the pages are prepared: original, kobo-clara, kindle-paperwhite, tablet (if not set, the default profile is used)
  -config
   -output      Set default output path
   -provider    Set default provider
+  -format      Set default format
+  -profile     Set default profile
+ -bundle
+  -manga       Set manga to bundle
+  -volume      Set the number of the volume
+  -from        Set the first chapter of the volume
+  -to          Set the last chapter of the volume
+  -mapping     Use a JSON file giving the chapters of each volume instead of -volume, -from and -to
+  -path        If used, read the chapters from another path instead of the default one
+  -remove      Remove the chapters once bundled
+ -block
+  -archive     Set the cbz the page comes from
+  -page        Set the page to block (the first page is 1)
+ -gaps
+  -manga       Only search the chapters missing for this manga (if not set, every manga of the history)
+  -path        If used, search the chapters in another path instead of the default one
+  -repair      Download the missing chapters
+  -silent      Don't display download progress bar
+ -scan
+  -manga       Only scan the chapters of this manga (if not set, every manga of the output path)
+  -path        If used, scan another path instead of the default one
  -update
   -manga       Set manga to update (must have been loaded once before)
   -provider    Override download site
   -next        Set next chapter to download (rewrite history)
+  -trim        Trim the uniform borders of the pages: yes, no
+  -spread      Set what to do with the landscape pages: keep, split, rotate
 
 Example
  $ gomangareaderdl -fetch -provider mangareader.net -manga shingeki-no-kyojin -chapter 100 -path .
@@ -69,7 +125,8 @@ https://github.com/francoiscolombo/gomangareaderdl`)
 func main() {
 
 	fmt.Println("\nWelcome on gomangareaderdl")
-	fmt.Println("--------------------------\n")
+	fmt.Println("--------------------------")
+	fmt.Println("")
 
 	fmt.Printf("version %s (%s)\n", versionNumber, versionName)
 
@@ -77,8 +134,22 @@ func main() {
 		settings.WriteDefaultSettings()
 	}
 
+	if settings.IsRulesExisting() == false {
+		settings.WriteDefaultRules()
+	}
+
+	for _, rules := range settings.ReadRules() {
+		fetch.RegisterRules(rules)
+	}
+
 	settings := settings.ReadSettings()
 
+	fetch.SetRetryPolicy(settings.Config.Retry)
+	fetch.SetRateLimit(*settings.Config.RateLimit)
+	for name, options := range settings.Config.Providers {
+		fetch.ConfigureProvider(name, options)
+	}
+
 	fmt.Println("- Settings loaded.")
 	fmt.Printf("  > Default output path is %s\n  > Default provider is %s\n\n", settings.Config.OutputPath, settings.Config.Provider)
 
@@ -88,35 +159,82 @@ func main() {
 	flag.BoolVar(&params.Config, "config", false, "execute command config")
 	flag.BoolVar(&params.Update, "update", false, "execute command update")
 	flag.BoolVar(&params.List, "list", false, "execute command list")
+	flag.BoolVar(&params.Bundle, "bundle", false, "execute command bundle")
+	flag.BoolVar(&params.Block, "block", false, "execute command block")
+	flag.BoolVar(&params.Gaps, "gaps", false, "execute command gaps")
+	flag.BoolVar(&params.Scan, "scan", false, "execute command scan")
 	flag.BoolVar(&params.Help, "help", false, "display help")
 
 	flag.StringVar(&params.Manga, "manga", "???", "manga to download or update")
-	flag.IntVar(&params.Chapter, "chapter", -1, "chapter to download")
-	flag.IntVar(&params.Next, "next", -1, "overwrite next chapter to download")
+	flag.StringVar(&params.Chapter, "chapter", "???", "chapter to download")
+	flag.StringVar(&params.Until, "until", "???", "last chapter to download")
+	flag.IntVar(&params.Max, "max", -1, "maximum number of chapters to download")
+	flag.StringVar(&params.Next, "next", "???", "overwrite next chapter to download")
 	flag.StringVar(&params.Provider, "provider", "???", "Set default provider")
 	flag.StringVar(&params.Path, "path", "???", "allow to download manga to another path instead of the default one")
 	flag.BoolVar(&params.Force, "force", false, "force download a previously downloaded chapter")
 	flag.BoolVar(&params.Silent, "silent", false, "don't display download progress bar")
+	flag.IntVar(&params.Jobs, "jobs", -1, "how many pages are downloaded at the same time")
+	flag.StringVar(&params.Format, "format", "???", "format of the downloaded chapters")
+	flag.StringVar(&params.Profile, "profile", "???", "how the pages are prepared for the device")
+	flag.StringVar(&params.Trim, "trim", "???", "trim the uniform borders of the pages")
+	flag.StringVar(&params.Spread, "spread", "???", "what to do with the landscape pages")
+	flag.IntVar(&params.Volume, "volume", -1, "volume to bundle")
+	flag.StringVar(&params.From, "from", "???", "first chapter of the volume")
+	flag.StringVar(&params.To, "to", "???", "last chapter of the volume")
+	flag.StringVar(&params.Mapping, "mapping", "???", "file giving the chapters of each volume")
+	flag.BoolVar(&params.Repair, "repair", false, "download the missing chapters")
+	flag.BoolVar(&params.Remove, "remove", false, "remove the chapters once bundled")
+	flag.StringVar(&params.Archive, "archive", "???", "archive the page to block comes from")
+	flag.IntVar(&params.Page, "page", -1, "page to block")
 	flag.StringVar(&params.Output, "output", "???", "set default output path for downloaded mangas")
 
 	flag.Parse()
 
+	// on Ctrl-C the downloads in progress are cancelled, a second one kills us right away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		signal.Stop(interrupt)
+		fmt.Println("\ninterrupted, stopping the downloads in progress...")
+		cancel()
+	}()
+
 	// depending the command, right?
 	if params.Fetch {
-		// fetch command allows the following parameters: manga, chapter, provider, path, force and silent
-		commands.ProcessFetchCommand(&settings, params.Manga, params.Chapter, params.Provider, params.Path, params.Force, params.Silent)
+		// fetch command allows the following parameters: manga, chapter, until, max, provider, path, force, silent, jobs, format and profile
+		commands.ProcessFetchCommand(ctx, &settings, params.Manga, params.Chapter, params.Until, params.Max, params.Provider, params.Path, params.Force, params.Silent, params.Jobs, params.Format, params.Profile)
 	} else if params.Config {
-		// config command allows the following parameters: output and provider
-		commands.ProcessConfigCommand(&settings, params.Output, params.Provider)
+		// config command allows the following parameters: output, provider, format and profile
+		commands.ProcessConfigCommand(&settings, params.Output, params.Provider, params.Format, params.Profile)
 	} else if params.Update {
-		// update command allows the following parameters: manga, provider and next
-		commands.ProcessUpdateCommand(&settings, params.Manga, params.Provider, params.Next)
+		// update command allows the following parameters: manga, provider, next, trim and spread
+		commands.ProcessUpdateCommand(&settings, params.Manga, params.Provider, params.Next, params.Trim, params.Spread)
 	} else if params.List {
 		// list command
-		commands.ProcessListCommand(&settings)
+		commands.ProcessListCommand(ctx, &settings)
+	} else if params.Bundle {
+		// bundle command allows the following parameters: manga, path, volume, from, to, mapping and remove
+		commands.ProcessBundleCommand(&settings, params.Manga, params.Path, params.From, params.To, params.Volume, params.Mapping, params.Remove)
+	} else if params.Block {
+		// block command allows the following parameters: archive and page
+		commands.ProcessBlockCommand(&settings, params.Archive, params.Page)
+	} else if params.Gaps {
+		// gaps command allows the following parameters: manga, path, repair and silent
+		commands.ProcessGapsCommand(ctx, &settings, params.Manga, params.Path, params.Repair, params.Silent)
+	} else if params.Scan {
+		// scan command allows the following parameters: manga and path
+		commands.ProcessScanCommand(&settings, params.Manga, params.Path)
 	} else {
 		// display usage & quit
 		usage()
 	}
 
+	if ctx.Err() != nil {
+		os.Exit(1)
+	}
+
 }
@@ -0,0 +1,117 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+const (
+	// hashSize is the size of the page reduced before computing its hash
+	hashSize = 32
+	// hashBits is the number of low frequencies kept in each direction, which gives a 64 bits hash
+	hashBits = 8
+	// blockDistance is the number of bits two hashes can differ by and still be the same page: the same page
+	// encoded again or scaled by another site never gives exactly the same hash
+	blockDistance = 6
+)
+
+// Hash is the perceptual hash of a page: close pages have close hashes, whatever their size, format or compression
+type Hash uint64
+
+/*
+HashImage compute the perceptual hash of a page: the page is reduced to a small grayscale square, and the hash tells
+which of its lowest frequencies are above their median
+*/
+func HashImage(img image.Image) Hash {
+	small := newRaster(img, true).resize(hashSize, hashSize)
+	pix, _, stride := small.pixels()
+	var values [hashSize][hashSize]float64
+	for y := 0; y < hashSize; y++ {
+		for x := 0; x < hashSize; x++ {
+			values[y][x] = float64(pix[y*stride+x])
+		}
+	}
+	// the discrete cosine transform, only for the frequencies we keep
+	var cosines [hashBits][hashSize]float64
+	for u := 0; u < hashBits; u++ {
+		for x := 0; x < hashSize; x++ {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * hashSize))
+		}
+	}
+	var frequencies []float64
+	for v := 0; v < hashBits; v++ {
+		for u := 0; u < hashBits; u++ {
+			sum := 0.0
+			for y := 0; y < hashSize; y++ {
+				for x := 0; x < hashSize; x++ {
+					sum += values[y][x] * cosines[u][x] * cosines[v][y]
+				}
+			}
+			frequencies = append(frequencies, sum)
+		}
+	}
+	// the average brightness is left out of the median, it would be above it most of the time
+	sorted := append([]float64(nil), frequencies[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var hash Hash
+	for i, frequency := range frequencies {
+		if frequency > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+/*
+HashReader decode a page and compute its perceptual hash
+*/
+func HashReader(reader io.Reader) (Hash, error) {
+	decoded, _, err := image.Decode(reader)
+	if err != nil {
+		return 0, err
+	}
+	return HashImage(decoded), nil
+}
+
+/*
+ParseHash read a hash written by String
+*/
+func ParseHash(value string) (Hash, error) {
+	hash, err := strconv.ParseUint(value, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash %s: %s", value, err)
+	}
+	return Hash(hash), nil
+}
+
+/*
+String write the hash in hexadecimal, the way it is stored in the settings
+*/
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+/*
+Distance send the number of bits which differ between two hashes
+*/
+func (h Hash) Distance(other Hash) int {
+	return bits.OnesCount64(uint64(h ^ other))
+}
+
+/*
+Blocked check if the hash is close enough to one of the blocklist
+*/
+func (h Hash) Blocked(blocklist []Hash) bool {
+	for _, blocked := range blocklist {
+		if h.Distance(blocked) <= blockDistance {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,384 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// the decoders of the formats used by the sites
+	_ "image/gif"
+	_ "image/png"
+)
+
+// defaultQuality is the JPEG quality used when a profile changes the pages without telling it
+const defaultQuality = 90
+
+// Profile describes how the pages are prepared for a device. The pages are scaled down to fit in Width x Height
+// (0 for no limit), converted to grayscale, and their gamma adjusted (a Gamma above 1 darkens the pages, 0 or 1 keeps
+// them as they are). The pages that have to be changed are encoded again in JPEG with the given Quality, a Quality
+// set forces the encoding even when nothing else changes
+type Profile struct {
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Grayscale bool    `json:"grayscale"`
+	Gamma     float64 `json:"gamma"`
+	Quality   int     `json:"quality"`
+}
+
+// Spread tells what to do with the landscape pages, which are usually two pages scanned together
+type Spread string
+
+const (
+	// SpreadKeep leaves the landscape pages as they are
+	SpreadKeep Spread = "keep"
+	// SpreadSplit cuts the landscape pages in two pages, the right one first since the mangas are read from right to left
+	SpreadSplit Spread = "split"
+	// SpreadRotate turns the landscape pages so that they fill a portrait screen, the right part on top
+	SpreadRotate Spread = "rotate"
+)
+
+// Options are all the steps to run on the pages of a chapter: the pages looking like one of the Blocklist are dropped
+// first, then the uniform borders are trimmed, the landscape pages are handled, and at last the pages are prepared
+// for the device
+type Options struct {
+	Profile   Profile
+	Trim      bool
+	Spread    Spread
+	Blocklist []Hash
+}
+
+// Page is a page prepared for the archive, Source is the index of the downloaded page it comes from. Undecoded is set
+// when the page could not be decoded, it is then archived as it was downloaded
+type Page struct {
+	File      string
+	Source    int
+	Undecoded bool
+}
+
+// Error is returned when a page could not be prepared
+type Error struct {
+	File string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("unable to process %s: %s", e.File, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+/*
+DefaultProfiles send the profiles available out of the box: the usual e-readers, a tablet, and the original pages
+*/
+func DefaultProfiles() map[string]Profile {
+	return map[string]Profile{
+		"original":          {},
+		"kobo-clara":        {Width: 1072, Height: 1448, Grayscale: true, Gamma: 1.8, Quality: 85},
+		"kindle-paperwhite": {Width: 1236, Height: 1648, Grayscale: true, Gamma: 1.8, Quality: 85},
+		"tablet":            {Width: 1536, Height: 2048, Quality: 90},
+	}
+}
+
+/*
+Enabled tells if something has to be done on the pages
+*/
+func (o Options) Enabled() bool {
+	return o.Profile != Profile{} || o.Trim || (o.Spread != "" && o.Spread != SpreadKeep) || len(o.Blocklist) > 0
+}
+
+/*
+ParseSpread check the name of a way to handle the landscape pages
+*/
+func ParseSpread(name string) (Spread, error) {
+	switch spread := Spread(name); spread {
+	case SpreadKeep, SpreadSplit, SpreadRotate:
+		return spread, nil
+	}
+	return "", fmt.Errorf("unknown spread <%s>, supported ones are: %s, %s, %s", name, SpreadKeep, SpreadSplit, SpreadRotate)
+}
+
+/*
+Pages prepare the pages of a chapter in the given directory, in the same order, and send the files to archive
+instead of the downloaded ones. The downloaded pages are left untouched, so that the chapter can be prepared again.
+The pages which can not be decoded (a format without decoder, like WebP) are kept as they are.
+It's an error to drop all the pages of a chapter, the blocklist is probably wrong then
+*/
+func Pages(files []string, dir string, options Options) ([]Page, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, &Error{File: dir, Err: err}
+	}
+	var pages []Page
+	for i, file := range files {
+		prepared, undecoded, err := preparePage(file, dir, i, options)
+		if err != nil {
+			return nil, &Error{File: file, Err: err}
+		}
+		for _, name := range prepared {
+			pages = append(pages, Page{File: name, Source: i, Undecoded: undecoded})
+		}
+	}
+	if len(pages) == 0 && len(files) > 0 {
+		return nil, &Error{File: dir, Err: fmt.Errorf("all the pages are in the blocklist")}
+	}
+	return pages, nil
+}
+
+/*
+preparePage run the steps on one page and write the result, which can be several pages when a landscape page is
+split, or none when the page is in the blocklist. The page is only encoded again if something changed, and it is
+copied as it is when it can not be decoded
+*/
+func preparePage(file, dir string, index int, options Options) (names []string, undecoded bool, err error) {
+	source, err := os.Open(file)
+	if err != nil {
+		return nil, false, err
+	}
+	defer source.Close()
+	decoded, _, err := image.Decode(source)
+	if err != nil {
+		names, err = copyPage(source, file, dir, index)
+		return names, true, err
+	}
+	if len(options.Blocklist) > 0 && HashImage(decoded).Blocked(options.Blocklist) {
+		return nil, false, nil
+	}
+	page := newRaster(decoded, options.Profile.Grayscale)
+	changed := options.Profile.Grayscale && !isGray(decoded)
+	if options.Trim {
+		if trimmed := page.trim(); trimmed != page {
+			page, changed = trimmed, true
+		}
+	}
+	parts := []*raster{page}
+	if width, height := page.size(); width > height {
+		switch options.Spread {
+		case SpreadSplit:
+			parts, changed = page.split(), true
+		case SpreadRotate:
+			parts, changed = []*raster{page.rotate()}, true
+		}
+	}
+	for i, part := range parts {
+		if resized := part.fit(options.Profile.Width, options.Profile.Height); resized != part {
+			parts[i], changed = resized, true
+		}
+	}
+	if options.Profile.Gamma > 0 && options.Profile.Gamma != 1 {
+		for _, part := range parts {
+			part.gamma(options.Profile.Gamma)
+		}
+		changed = true
+	}
+	if !changed && options.Profile.Quality == 0 {
+		names, err = copyPage(source, file, dir, index)
+		return names, false, err
+	}
+	for i, part := range parts {
+		name := filepath.Join(dir, fmt.Sprintf("page_%03d_%d.jpg", index+1, i+1))
+		if err = part.save(name, options.Profile.Quality); err != nil {
+			return nil, false, err
+		}
+		names = append(names, name)
+	}
+	return names, false, nil
+}
+
+// copyPage copy the downloaded page as it is, with the name it would have been given once prepared
+func copyPage(source io.ReadSeeker, file, dir string, index int) ([]string, error) {
+	name := filepath.Join(dir, fmt.Sprintf("page_%03d_1%s", index+1, strings.ToLower(filepath.Ext(file))))
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return []string{name}, copyTo(source, name)
+}
+
+func isGray(img image.Image) bool {
+	_, ok := img.(*image.Gray)
+	return ok
+}
+
+func copyTo(source io.Reader, name string) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, source); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// raster is a page being processed, either in grayscale or in RGBA
+type raster struct {
+	img image.Image
+}
+
+/*
+newRaster copy the decoded page in a raster we can work on, the transparent parts become white
+*/
+func newRaster(decoded image.Image, grayscale bool) *raster {
+	bounds := decoded.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if grayscale {
+		gray := image.NewGray(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				r, g, b, a := decoded.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				white := 0xffff - a
+				// the luminance, with the weights of the JPEG
+				lum := (19595*(r+white) + 38470*(g+white) + 7471*(b+white) + 1<<15) >> 24
+				gray.Pix[y*gray.Stride+x] = uint8(lum)
+			}
+		}
+		return &raster{img: gray}
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, a := decoded.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			white := 0xffff - a
+			i := y*rgba.Stride + x*4
+			rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2], rgba.Pix[i+3] = uint8((r+white)>>8), uint8((g+white)>>8), uint8((b+white)>>8), 0xff
+		}
+	}
+	return &raster{img: rgba}
+}
+
+// pixels send the bytes of the raster, the number of bytes per pixel and per row
+func (r *raster) pixels() (pix []uint8, channels, stride int) {
+	switch img := r.img.(type) {
+	case *image.Gray:
+		return img.Pix, 1, img.Stride
+	case *image.RGBA:
+		return img.Pix, 4, img.Stride
+	}
+	return nil, 0, 0
+}
+
+func (r *raster) size() (width, height int) {
+	bounds := r.img.Bounds()
+	return bounds.Dx(), bounds.Dy()
+}
+
+// blank create an empty raster of the same kind
+func (r *raster) blank(width, height int) *raster {
+	if _, ok := r.img.(*image.Gray); ok {
+		return &raster{img: image.NewGray(image.Rect(0, 0, width, height))}
+	}
+	return &raster{img: image.NewRGBA(image.Rect(0, 0, width, height))}
+}
+
+/*
+fit scale the page down so that it fits in the given size, keeping its proportions. The pages are never scaled up,
+the same raster is sent back when there is nothing to do
+*/
+func (r *raster) fit(maxWidth, maxHeight int) *raster {
+	width, height := r.size()
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = float64(maxWidth) / float64(width)
+	}
+	if maxHeight > 0 && height > maxHeight {
+		scale = math.Min(scale, float64(maxHeight)/float64(height))
+	}
+	if scale >= 1 {
+		return r
+	}
+	newWidth := int(math.Max(1, math.Round(float64(width)*scale)))
+	newHeight := int(math.Max(1, math.Round(float64(height)*scale)))
+	return r.resize(newWidth, newHeight)
+}
+
+/*
+resize scale the page down with an area average: every pixel of the new page is the mean of the pixels it covers,
+which keeps the thin lines of the drawings readable
+*/
+func (r *raster) resize(newWidth, newHeight int) *raster {
+	width, height := r.size()
+	src, channels, srcStride := r.pixels()
+	resized := r.blank(newWidth, newHeight)
+	dst, _, dstStride := resized.pixels()
+	xSpans := spans(width, newWidth)
+	ySpans := spans(height, newHeight)
+	sums := make([]uint32, channels)
+	for y := 0; y < newHeight; y++ {
+		y0, y1 := ySpans[y][0], ySpans[y][1]
+		for x := 0; x < newWidth; x++ {
+			x0, x1 := xSpans[x][0], xSpans[x][1]
+			for c := range sums {
+				sums[c] = 0
+			}
+			for sy := y0; sy < y1; sy++ {
+				row := src[sy*srcStride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < channels; c++ {
+						sums[c] += uint32(row[sx*channels+c])
+					}
+				}
+			}
+			count := uint32((y1 - y0) * (x1 - x0))
+			for c := 0; c < channels; c++ {
+				dst[y*dstStride+x*channels+c] = uint8((sums[c] + count/2) / count)
+			}
+		}
+	}
+	return resized
+}
+
+// spans split a length in n parts and send their bounds, each part is at least one pixel long so that a page can
+// be scaled up as well
+func spans(length, n int) [][2]int {
+	bounds := make([][2]int, n)
+	for i := range bounds {
+		start, end := i*length/n, (i+1)*length/n
+		if end <= start {
+			end = start + 1
+		}
+		bounds[i] = [2]int{start, end}
+	}
+	return bounds
+}
+
+/*
+gamma adjust the gamma of the page, the e-ink screens need darker pages than the usual screens
+*/
+func (r *raster) gamma(gamma float64) {
+	var table [256]uint8
+	for i := range table {
+		table[i] = uint8(math.Round(255 * math.Pow(float64(i)/255, gamma)))
+	}
+	pix, channels, _ := r.pixels()
+	for i := range pix {
+		// the alpha of the RGBA pages is left alone
+		if channels == 4 && i%4 == 3 {
+			continue
+		}
+		pix[i] = table[pix[i]]
+	}
+}
+
+/*
+save encode the page in JPEG
+*/
+func (r *raster) save(name string, quality int) error {
+	if quality <= 0 || quality > 100 {
+		quality = defaultQuality
+	}
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = jpeg.Encode(out, r.img, &jpeg.Options{Quality: quality}); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
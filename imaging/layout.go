@@ -0,0 +1,109 @@
+package imaging
+
+import (
+	"image"
+)
+
+const (
+	// borderTolerance is how far from the color of the border a pixel can be and still be part of it, the scans are
+	// never perfectly uniform
+	borderTolerance = 32
+	// borderNoise is the part of a line, in per thousand, which can be different from the border, for the dust
+	borderNoise = 5
+)
+
+// luminance send the brightness of a pixel
+func (r *raster) luminance(x, y int) int {
+	pix, channels, stride := r.pixels()
+	i := y*stride + x*channels
+	if channels == 1 {
+		return int(pix[i])
+	}
+	return (299*int(pix[i]) + 587*int(pix[i+1]) + 114*int(pix[i+2])) / 1000
+}
+
+/*
+trim remove the uniform borders of the page, white or black. The color of the border is the one of the top left
+corner, and the page is kept as it is when it has no border or when it is uniform as a whole
+*/
+func (r *raster) trim() *raster {
+	width, height := r.size()
+	background := r.luminance(0, 0)
+	isBorder := func(x0, y0, dx, dy, length int) bool {
+		different := 0
+		for i := 0; i < length; i++ {
+			lum := r.luminance(x0+i*dx, y0+i*dy)
+			if lum-background > borderTolerance || background-lum > borderTolerance {
+				different++
+				if different*1000 > length*borderNoise {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	top, bottom, left, right := 0, height, 0, width
+	for top < bottom && isBorder(0, top, 1, 0, width) {
+		top++
+	}
+	for bottom > top && isBorder(0, bottom-1, 1, 0, width) {
+		bottom--
+	}
+	if top >= bottom {
+		return r
+	}
+	for left < right && isBorder(left, top, 0, 1, bottom-top) {
+		left++
+	}
+	for right > left && isBorder(right-1, top, 0, 1, bottom-top) {
+		right--
+	}
+	if left >= right || (top == 0 && bottom == height && left == 0 && right == width) {
+		return r
+	}
+	return r.crop(image.Rect(left, top, right, bottom))
+}
+
+/*
+crop send the given part of the page, in a raster of its own
+*/
+func (r *raster) crop(rect image.Rectangle) *raster {
+	cropped := r.blank(rect.Dx(), rect.Dy())
+	src, channels, srcStride := r.pixels()
+	dst, _, dstStride := cropped.pixels()
+	for y := 0; y < rect.Dy(); y++ {
+		start := (rect.Min.Y+y)*srcStride + rect.Min.X*channels
+		copy(dst[y*dstStride:], src[start:start+rect.Dx()*channels])
+	}
+	return cropped
+}
+
+/*
+split cut a landscape page in two pages, the right one first
+*/
+func (r *raster) split() []*raster {
+	width, height := r.size()
+	middle := width / 2
+	return []*raster{
+		r.crop(image.Rect(middle, 0, width, height)),
+		r.crop(image.Rect(0, 0, middle, height)),
+	}
+}
+
+/*
+rotate turn the page a quarter counterclockwise, so that the right part of a landscape page is on top
+*/
+func (r *raster) rotate() *raster {
+	width, height := r.size()
+	rotated := r.blank(height, width)
+	src, channels, srcStride := r.pixels()
+	dst, _, dstStride := rotated.pixels()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*srcStride + x*channels
+			j := (width-1-x)*dstStride + y*channels
+			copy(dst[j:j+channels], src[i:i+channels])
+		}
+	}
+	return rotated
+}
@@ -0,0 +1,52 @@
+package output
+
+import (
+	"github.com/francoiscolombo/gomangareaderdl/createcbz"
+)
+
+// cbz is the comics archive format, readable by nearly all the comics readers
+type cbz struct {
+	options Options
+}
+
+func init() {
+	RegisterFormat("cbz", func(options Options) Format {
+		return &cbz{options: options}
+	})
+}
+
+/*
+Extension send the extension of the comics archives
+*/
+func (f *cbz) Extension() string {
+	return ".cbz"
+}
+
+/*
+UsesMetadata tells if the ComicInfo.xml is written, it's the only place of the archive where the description goes
+*/
+func (f *cbz) UsesMetadata() bool {
+	return !f.options.DisableComicInfo
+}
+
+/*
+Write create the comics archive, with its ComicInfo.xml unless it is disabled
+*/
+func (f *cbz) Write(filename string, book Book) error {
+	options := createcbz.Options{
+		Compression: f.options.Compression,
+	}
+	if !f.options.DisableComicInfo {
+		info := createcbz.NewComicInfo(book.Series, "", "")
+		info.Writer = book.Author
+		info.Summary = book.Summary
+		info.LanguageISO = book.Language
+		if len(book.Chapters) == 1 {
+			info.Number = book.Chapters[0].Number.String()
+			info.Title = book.Chapters[0].Title
+			info.Web = book.Chapters[0].URL
+		}
+		options.ComicInfo = info
+	}
+	return createcbz.ZipFiles(filename, book.Pages(), options)
+}
@@ -0,0 +1,47 @@
+package output
+
+import (
+	"github.com/francoiscolombo/gomangareaderdl/createepub"
+)
+
+// epub is the fixed-layout EPUB 3 format, for the e-readers
+type epub struct{}
+
+func init() {
+	RegisterFormat("epub", func(options Options) Format {
+		return &epub{}
+	})
+}
+
+/*
+Extension send the extension of the EPUB files
+*/
+func (f *epub) Extension() string {
+	return ".epub"
+}
+
+/*
+UsesMetadata send true, the EPUB files always have a title, an author and a summary
+*/
+func (f *epub) UsesMetadata() bool {
+	return true
+}
+
+/*
+Write create the EPUB, with one entry per chapter in its table of contents
+*/
+func (f *epub) Write(filename string, book Book) error {
+	content := createepub.Book{
+		Title:    book.Title(),
+		Author:   book.Author,
+		Summary:  book.Summary,
+		Language: book.Language,
+	}
+	for _, chapter := range book.Chapters {
+		content.Chapters = append(content.Chapters, createepub.Chapter{
+			Title: chapter.Title,
+			Pages: chapter.Pages,
+		})
+	}
+	return createepub.Write(filename, content)
+}
@@ -0,0 +1,130 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/francoiscolombo/gomangareaderdl/atomicfile"
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+)
+
+// folder keeps the pages as they are, in a directory, for the tools that want the raw images
+type folder struct{}
+
+// sidecarName is the name of the file describing the pages of the directory
+const sidecarName = "chapter.json"
+
+// sidecar describe where the pages of a folder come from
+type sidecar struct {
+	Series     string           `json:"series"`
+	Provider   string           `json:"provider"`
+	Downloaded time.Time        `json:"downloaded"`
+	Chapters   []sidecarChapter `json:"chapters"`
+}
+
+type sidecarChapter struct {
+	Number chapterid.ID  `json:"chapter"`
+	Title  string        `json:"title"`
+	URL    string        `json:"url"`
+	Pages  []sidecarPage `json:"pages"`
+}
+
+type sidecarPage struct {
+	File  string `json:"file"`
+	URL   string `json:"url,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+func init() {
+	RegisterFormat("folder", func(options Options) Format {
+		return &folder{}
+	})
+}
+
+/*
+Extension send nothing, the folders don't have an extension
+*/
+func (f *folder) Extension() string {
+	return ""
+}
+
+/*
+UsesMetadata send true, the series is written in the chapter.json
+*/
+func (f *folder) UsesMetadata() bool {
+	return true
+}
+
+/*
+Write copy the pages in the directory, renamed page_001.jpg, page_002.png... with a chapter.json describing them.
+When there are several chapters, each one gets its own sub directory. Like the archives, the directory is prepared
+under a temporary name, and only renamed to its real name once complete.
+*/
+func (f *folder) Write(filename string, book Book) error {
+	return atomicfile.WriteDir(filename, func(tmpDir string) error {
+		description := sidecar{
+			Series:     book.Series,
+			Provider:   book.Provider,
+			Downloaded: time.Now().UTC().Truncate(time.Second),
+		}
+		for _, chapter := range book.Chapters {
+			dir := ""
+			if len(book.Chapters) > 1 {
+				dir = chapter.Number.Padded()
+				if err := os.Mkdir(filepath.Join(tmpDir, dir), 0755); err != nil {
+					return err
+				}
+			}
+			described := sidecarChapter{
+				Number: chapter.Number,
+				Title:  chapter.Title,
+				URL:    chapter.URL,
+			}
+			for i, page := range chapter.Pages {
+				name := filepath.Join(dir, fmt.Sprintf("page_%03d%s", i+1, strings.ToLower(filepath.Ext(page))))
+				if err := copyFile(page, filepath.Join(tmpDir, name)); err != nil {
+					return err
+				}
+				described.Pages = append(described.Pages, sidecarPage{
+					File:  filepath.ToSlash(name),
+					URL:   at(chapter.PagesURL, i),
+					Image: at(chapter.ImagesURL, i),
+				})
+			}
+			description.Chapters = append(description.Chapters, described)
+		}
+		content, _ := json.MarshalIndent(description, "", " ")
+		return ioutil.WriteFile(filepath.Join(tmpDir, sidecarName), content, 0644)
+	})
+}
+
+// at send the element i of the list, or nothing if the list is too short
+func at(list []string, i int) string {
+	if i < len(list) {
+		return list[i]
+	}
+	return ""
+}
+
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,111 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+	"github.com/francoiscolombo/gomangareaderdl/createcbz"
+)
+
+// Format writes the pages downloaded for a manga in a file readable by humans or by other tools
+type Format interface {
+	// Extension send the extension of the files written, with the dot
+	Extension() string
+	// UsesMetadata tells if the description of the manga is written, so that it is only read from the site if needed
+	UsesMetadata() bool
+	// Write create the file from the pages of the book
+	Write(filename string, book Book) error
+}
+
+// Book is what a format writes: the description of the manga and one or several of its chapters
+type Book struct {
+	Series   string
+	Author   string
+	Summary  string
+	Language string
+	Provider string
+	Chapters []Chapter
+}
+
+// Chapter is a downloaded chapter, with its pages in reading order. PagesURL and ImagesURL are the urls of the
+// pages and of the images they come from, in the same order, when they are known
+type Chapter struct {
+	Number    chapterid.ID
+	Title     string
+	URL       string
+	Pages     []string
+	PagesURL  []string
+	ImagesURL []string
+}
+
+// Options are the settings of the formats, each format only uses the ones it understands
+type Options struct {
+	Compression      createcbz.Compression
+	DisableComicInfo bool
+}
+
+var formats = make(map[string]func(options Options) Format)
+
+/*
+RegisterFormat add a format to the registry, under the name used by the -format flag
+*/
+func RegisterFormat(name string, create func(options Options) Format) {
+	formats[name] = create
+}
+
+/*
+GetFormat create the format with the given name, and return an error listing the supported ones if it does not exist
+*/
+func GetFormat(name string, options Options) (Format, error) {
+	create, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format <%s>, supported formats are: %s", name, strings.Join(FormatNames(), ", "))
+	}
+	return create(options), nil
+}
+
+/*
+FormatNames send the sorted list of the names of all the registered formats
+*/
+func FormatNames() (names []string) {
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+/*
+Extensions send the extensions of the files written by all the registered formats, the formats writing a directory
+are left out
+*/
+func Extensions() (extensions []string) {
+	for _, name := range FormatNames() {
+		if extension := formats[name](Options{}).Extension(); extension != "" {
+			extensions = append(extensions, extension)
+		}
+	}
+	return
+}
+
+/*
+Title send the title of the book: the series, followed by the chapter when there is only one
+*/
+func (b Book) Title() string {
+	if len(b.Chapters) == 1 {
+		return fmt.Sprintf("%s - %s", b.Series, b.Chapters[0].Title)
+	}
+	return b.Series
+}
+
+/*
+Pages send the pages of all the chapters of the book, in reading order
+*/
+func (b Book) Pages() (pages []string) {
+	for _, chapter := range b.Chapters {
+		pages = append(pages, chapter.Pages...)
+	}
+	return
+}
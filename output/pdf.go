@@ -0,0 +1,50 @@
+package output
+
+import (
+	"strings"
+
+	"github.com/francoiscolombo/gomangareaderdl/createpdf"
+)
+
+// pdf is the PDF format, for archiving and printing
+type pdf struct{}
+
+func init() {
+	RegisterFormat("pdf", func(options Options) Format {
+		return &pdf{}
+	})
+}
+
+/*
+Extension send the extension of the PDF files
+*/
+func (f *pdf) Extension() string {
+	return ".pdf"
+}
+
+/*
+UsesMetadata send true, the title and the author go in the PDF metadata
+*/
+func (f *pdf) UsesMetadata() bool {
+	return true
+}
+
+/*
+Write create the PDF, the chapters are listed in its subject and in its outline
+*/
+func (f *pdf) Write(filename string, book Book) error {
+	content := createpdf.Book{
+		Title:  book.Title(),
+		Author: book.Author,
+	}
+	var titles []string
+	for _, chapter := range book.Chapters {
+		titles = append(titles, chapter.Title)
+		content.Chapters = append(content.Chapters, createpdf.Chapter{
+			Title: chapter.Title,
+			Pages: chapter.Pages,
+		})
+	}
+	content.Subject = strings.Join(titles, ", ")
+	return createpdf.Write(filename, content)
+}
@@ -1,38 +1,106 @@
 package settings
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/francoiscolombo/gomangareaderdl/chapterid"
+	"github.com/francoiscolombo/gomangareaderdl/createcbz"
 	"github.com/francoiscolombo/gomangareaderdl/fetch"
+	"github.com/francoiscolombo/gomangareaderdl/imaging"
+	"github.com/francoiscolombo/gomangareaderdl/output"
 	"github.com/olekukonko/tablewriter"
 )
 
+const (
+	defaultJobs     = 4
+	defaultHostJobs = 2
+	defaultFormat   = "cbz"
+	defaultProfile  = "original"
+	// historyVersion is the version of the history format, the version 2 added the chapters downloaded
+	historyVersion = 2
+)
+
 // Settings is the structure that allowed to store the default configuration and the download history for all the mangas we are downloading
 type Settings struct {
 	Config  Config  `json:"config"`
 	History History `json:"history"`
 }
 
-// Config only store the default configuration, like output path, provider and if we have to use directories to store mangas
+// Config only store the default configuration, like output path, provider and if we have to use directories to store mangas.
+// Providers allows to override the defaults for the requests sent to one provider, Profiles are the ways the pages can
+// be prepared for a device and Profile the one used by default. The pages looking like one of the Blocklist are dropped
 type Config struct {
-	OutputPath string `json:"outputPath"`
-	Provider   string `json:"provider"`
+	OutputPath       string                           `json:"outputPath"`
+	Provider         string                           `json:"provider"`
+	Jobs             int                              `json:"jobs"`
+	HostJobs         int                              `json:"hostJobs"`
+	Format           string                           `json:"format"`
+	Compression      createcbz.Compression            `json:"compression"`
+	DisableComicInfo bool                             `json:"disableComicInfo"`
+	Retry            fetch.RetryPolicy                `json:"retry"`
+	RateLimit        *fetch.RateLimit                 `json:"rateLimit"`
+	Providers        map[string]fetch.ProviderOptions `json:"providers,omitempty"`
+	Profile          string                           `json:"profile"`
+	Profiles         map[string]imaging.Profile       `json:"profiles"`
+	Blocklist        []BlockedPage                    `json:"blocklist"`
+}
+
+// BlockedPage is a page we never want in the archives, like the credits or the ads of a scanlator, with the page of
+// an archive it was taken from
+type BlockedPage struct {
+	Hash    string `json:"hash"`
+	Archive string `json:"archive"`
+	Page    int    `json:"page"`
 }
 
 // History is the manga download history, so it's an array of all the mangas we are downloading
 type History struct {
-	Titles []Manga `json:"titles"`
+	Version int     `json:"version"`
+	Titles  []Manga `json:"titles"`
 }
 
-// Manga keep the download history for every mangas that we are suscribing
+// Manga keep the download history for every mangas that we are suscribing, with the way its pages are processed.
+// Chapter is the next chapter to download, and Chapters the ones already downloaded, in reading order
 type Manga struct {
-	Title    string `json:"title"`
-	Chapter  int    `json:"chapter"`
-	Provider string `json:"provider"`
+	Title      string              `json:"title"`
+	Chapter    chapterid.ID        `json:"chapter"`
+	Provider   string              `json:"provider"`
+	Processing Processing          `json:"processing"`
+	Chapters   []DownloadedChapter `json:"chapters,omitempty"`
+}
+
+// DownloadedChapter is a chapter downloaded for a manga: where and when it was downloaded, and the file written for
+// it with its number of pages, its size and the SHA-256 of its content. The chapters recorded when the history was
+// migrated from the version 1 have the date of their file, and no provider if the manga had none
+type DownloadedChapter struct {
+	Chapter    chapterid.ID `json:"chapter"`
+	Provider   string       `json:"provider"`
+	Downloaded time.Time    `json:"downloaded"`
+	Pages      int          `json:"pages"`
+	Size       int64        `json:"size"`
+	SHA256     string       `json:"sha256"`
+	Path       string       `json:"path"`
+}
+
+// Processing tells how the pages of a manga are processed on top of the device profile: with their uniform borders
+// trimmed or not, and what to do with the landscape pages
+type Processing struct {
+	Trim   bool           `json:"trim"`
+	Spread imaging.Spread `json:"spread"`
+}
+
+func defaultRateLimit() *fetch.RateLimit {
+	rate := fetch.DefaultRateLimit()
+	return &rate
 }
 
 func getSettingsPath() string {
@@ -44,6 +112,15 @@ func getSettingsPath() string {
 	return user.HomeDir + "/.gomangareaderdl.json"
 }
 
+func getRulesPath() string {
+	user, err := user.Current()
+	if err != nil {
+		fmt.Printf("Error when trying to get current user: %s\n", err)
+		os.Exit(1)
+	}
+	return user.HomeDir + "/.gomangareaderdl.rules.json"
+}
+
 /*
 IsSettingsExisting allows to check if the settings file already exists or no
 */
@@ -66,11 +143,20 @@ func WriteDefaultSettings() {
 	fmt.Printf("Hello %s ! You don't have any settings yet. I can see that your homedir is %s, I will use it if you don't mind.\n", user.Name, user.HomeDir)
 	settings := Settings{
 		Config{
-			OutputPath: fmt.Sprintf("%s/mangas", user.HomeDir),
-			Provider:   "mangareader.net",
+			OutputPath:  fmt.Sprintf("%s/mangas", user.HomeDir),
+			Provider:    "mangareader.net",
+			Jobs:        defaultJobs,
+			HostJobs:    defaultHostJobs,
+			Format:      defaultFormat,
+			Compression: createcbz.Store,
+			Retry:       fetch.DefaultRetryPolicy(),
+			RateLimit:   defaultRateLimit(),
+			Profile:     defaultProfile,
+			Profiles:    imaging.DefaultProfiles(),
 		},
 		History{
-			Titles: []Manga{},
+			Version: historyVersion,
+			Titles:  []Manga{},
 		},
 	}
 	file, _ := json.MarshalIndent(settings, "", " ")
@@ -102,9 +188,138 @@ func ReadSettings() (settings Settings) {
 	// jsonFile's content into 'settings' which we defined above
 	json.Unmarshal(byteValue, &settings)
 
+	// settings written by an older version don't have these ones yet
+	if settings.Config.Jobs == 0 {
+		settings.Config.Jobs = defaultJobs
+	}
+	if settings.Config.HostJobs == 0 {
+		settings.Config.HostJobs = defaultHostJobs
+	}
+	if settings.Config.Format == "" {
+		settings.Config.Format = defaultFormat
+	}
+	if settings.Config.Compression == "" {
+		settings.Config.Compression = createcbz.Store
+	}
+	if settings.Config.Retry.MaxAttempts == 0 {
+		settings.Config.Retry = fetch.DefaultRetryPolicy()
+	}
+	if settings.Config.RateLimit == nil {
+		settings.Config.RateLimit = defaultRateLimit()
+	}
+	if settings.Config.Profile == "" {
+		settings.Config.Profile = defaultProfile
+	}
+	if settings.Config.Profiles == nil {
+		settings.Config.Profiles = make(map[string]imaging.Profile)
+	}
+	// the profiles available out of the box are always there, unless they are redefined
+	for name, profile := range imaging.DefaultProfiles() {
+		if _, ok := settings.Config.Profiles[name]; !ok {
+			settings.Config.Profiles[name] = profile
+		}
+	}
+
+	if settings.History.Version < historyVersion {
+		settings.History = migrateHistory(settings.Config, settings.History)
+		settings.History.Version = historyVersion
+		if len(settings.History.Titles) > 0 {
+			WriteSettings(settings)
+			fmt.Println("History migrated, the chapters found in the output path are recorded.")
+		}
+	}
+
 	return
 }
 
+/*
+migrateHistory convert a history which only knows the next chapter to download of each manga: the chapters already
+downloaded are searched in the default output path, with the extension of the default format, and recorded. The
+chapters which can't be read are skipped, so that the migration is only done once
+*/
+func migrateHistory(config Config, history History) History {
+	extension := ""
+	if format, err := output.GetFormat(config.Format, output.Options{}); err == nil {
+		extension = format.Extension()
+	}
+	for i, title := range history.Titles {
+		if len(title.Chapters) > 0 {
+			continue
+		}
+		history.Titles[i].Chapters = migrateChapters(config.OutputPath, title, extension)
+	}
+	return history
+}
+
+/*
+migrateChapters read the chapters of a title found in the output path, the ones which can't be read are reported and
+skipped
+*/
+func migrateChapters(outputPath string, title Manga, extension string) (chapters []DownloadedChapter) {
+	downloaded, err := fetch.DownloadedChapters(outputPath, title.Title, extension)
+	if err != nil {
+		fmt.Printf("  > the chapters of %s are skipped: %s\n", title.Title, err)
+		return nil
+	}
+	for chapter, fileName := range downloaded {
+		record, err := DescribeChapter(chapter, title.Provider, fileName)
+		if err != nil {
+			fmt.Printf("  > %s is skipped: %s\n", filepath.Base(fileName), err)
+			continue
+		}
+		chapters = addChapter(chapters, record)
+	}
+	return chapters
+}
+
+/*
+DescribeChapter read what the history records about a chapter from the file written for it, the date of the
+download is the date of the file. The pages are only counted for the cbz
+*/
+func DescribeChapter(chapter chapterid.ID, provider, fileName string) (record DownloadedChapter, err error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return
+	}
+	pages := 0
+	if strings.HasSuffix(fileName, ".cbz") {
+		if pages, err = createcbz.CountPages(fileName); err != nil {
+			return
+		}
+	}
+	archive, err := fetch.DescribeArchive(fileName, pages)
+	if err != nil {
+		return
+	}
+	return DownloadedChapter{
+		Chapter:    chapter,
+		Provider:   provider,
+		Downloaded: info.ModTime().UTC().Truncate(time.Second),
+		Pages:      archive.Pages,
+		Size:       archive.Size,
+		SHA256:     archive.SHA256,
+		Path:       archive.Path,
+	}, nil
+}
+
+/*
+addChapter add a chapter to the chapters downloaded, in reading order. A chapter downloaded again replaces the
+previous one
+*/
+func addChapter(chapters []DownloadedChapter, record DownloadedChapter) []DownloadedChapter {
+	for i, downloaded := range chapters {
+		if downloaded.Chapter == record.Chapter {
+			chapters[i] = record
+			return chapters
+		}
+	}
+	chapters = append(chapters, record)
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].Chapter.Less(chapters[j].Chapter)
+	})
+	return chapters
+}
+
 /*
 WriteSettings write a settings file. used to change the default config or add manga to history download
 */
@@ -113,11 +328,98 @@ func WriteSettings(settings Settings) {
 	_ = ioutil.WriteFile(getSettingsPath(), file, 0644)
 }
 
+/*
+IsRulesExisting allows to check if the scraping rules file already exists or no
+*/
+func IsRulesExisting() bool {
+	if _, err := os.Stat(getRulesPath()); !os.IsNotExist(err) {
+		return true
+	}
+	return false
+}
+
+/*
+WriteDefaultRules write the scraping rules of the sites supported out of the box, so that they can be used as
+a sample for adding new sites
+*/
+func WriteDefaultRules() {
+	file, _ := json.MarshalIndent(fetch.DefaultRules(), "", " ")
+	_ = ioutil.WriteFile(getRulesPath(), file, 0644)
+}
+
+/*
+ReadRules read the scraping rules file, every site described in it becomes an available provider
+*/
+func ReadRules() (rules []fetch.Rules) {
+	rulesPath := getRulesPath()
+	fmt.Printf("Loading scraping rules from %s...\n", rulesPath)
+	byteValue, err := ioutil.ReadFile(rulesPath)
+	if err != nil {
+		fmt.Printf("Error when trying to open scraping rules file: %s\n", err)
+		return
+	}
+	if err = json.Unmarshal(byteValue, &rules); err != nil {
+		fmt.Printf("Error when trying to read scraping rules file: %s\n", err)
+	}
+	return
+}
+
+/*
+GetProfile send the profile with the given name, and return an error listing the available ones if it does not exist
+*/
+func GetProfile(cfg Settings, name string) (imaging.Profile, error) {
+	profile, ok := cfg.Config.Profiles[name]
+	if !ok {
+		var names []string
+		for available := range cfg.Config.Profiles {
+			names = append(names, available)
+		}
+		sort.Strings(names)
+		return profile, fmt.Errorf("unknown profile <%s>, available profiles are: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+/*
+ReadBlocklist send the hashes of the blocklist, the invalid ones are reported and ignored
+*/
+func ReadBlocklist(cfg Settings) (blocklist []imaging.Hash) {
+	for _, blocked := range cfg.Config.Blocklist {
+		hash, err := imaging.ParseHash(blocked.Hash)
+		if err != nil {
+			fmt.Printf("Error in the blocklist: %s\n", err)
+			continue
+		}
+		blocklist = append(blocklist, hash)
+	}
+	return
+}
+
+/*
+AddToBlocklist add the hash of a page to the blocklist, unless it is already there
+*/
+func AddToBlocklist(cfg Settings, hash imaging.Hash, archive string, page int) Settings {
+	for _, blocked := range cfg.Config.Blocklist {
+		if blocked.Hash == hash.String() {
+			fmt.Println("This page is already in the blocklist.")
+			return cfg
+		}
+	}
+	cfg.Config.Blocklist = append(cfg.Config.Blocklist, BlockedPage{
+		Hash:    hash.String(),
+		Archive: archive,
+		Page:    page,
+	})
+	WriteSettings(cfg)
+	fmt.Println("Blocklist updated.")
+	return cfg
+}
+
 /*
 SearchLastChapter send the last chapter in the history for a manga, or 1 if no history exists yet
 */
-func SearchLastChapter(settings Settings, manga string) (lastChapter int) {
-	lastChapter = 1
+func SearchLastChapter(settings Settings, manga string) (lastChapter chapterid.ID) {
+	lastChapter = chapterid.FromInt(1)
 	for _, title := range settings.History.Titles {
 		if title.Title == manga {
 			lastChapter = title.Chapter
@@ -127,19 +429,136 @@ func SearchLastChapter(settings Settings, manga string) (lastChapter int) {
 	return
 }
 
+/*
+SearchProcessing send the way the pages of a manga are processed, nothing is done by default
+*/
+func SearchProcessing(settings Settings, manga string) Processing {
+	for _, title := range settings.History.Titles {
+		if title.Title == manga {
+			return title.Processing
+		}
+	}
+	return Processing{}
+}
+
+/*
+UpdateProcessing change the way the pages of a manga are processed, the manga is added to the history if needed
+*/
+func UpdateProcessing(cfg Settings, manga string, processing Processing) Settings {
+	found := false
+	for i, title := range cfg.History.Titles {
+		if title.Title == manga {
+			cfg.History.Titles[i].Processing = processing
+			found = true
+		}
+	}
+	if !found {
+		cfg.History.Titles = append(cfg.History.Titles, Manga{
+			Title:      manga,
+			Chapter:    chapterid.FromInt(1),
+			Provider:   cfg.Config.Provider,
+			Processing: processing,
+		})
+	}
+	WriteSettings(cfg)
+	fmt.Println("Processing updated.")
+	return cfg
+}
+
+/*
+RecordChapter add a chapter to the chapters downloaded for a manga, the manga is added to the history if needed. The
+settings are written right away, so that the chapters downloaded are kept even if the download stops
+*/
+func RecordChapter(cfg Settings, manga string, chapter chapterid.ID, provider string, archive fetch.Archive) Settings {
+	record := DownloadedChapter{
+		Chapter:    chapter,
+		Provider:   provider,
+		Downloaded: time.Now().UTC().Truncate(time.Second),
+		Pages:      archive.Pages,
+		Size:       archive.Size,
+		SHA256:     archive.SHA256,
+		Path:       archive.Path,
+	}
+	found := false
+	for i, title := range cfg.History.Titles {
+		if title.Title == manga {
+			cfg.History.Titles[i].Chapters = addChapter(title.Chapters, record)
+			found = true
+		}
+	}
+	if !found {
+		cfg.History.Titles = append(cfg.History.Titles, Manga{
+			Title:    manga,
+			Chapter:  chapterid.FromInt(1),
+			Provider: provider,
+			Chapters: []DownloadedChapter{record},
+		})
+	}
+	WriteSettings(cfg)
+	return cfg
+}
+
+/*
+RebuildHistory add the chapters found on disk for a manga to its history, the chapters already recorded are replaced.
+The next chapter to download moves after the last chapter found if needed. A manga not in the history yet is added,
+with the provider of its last chapter
+*/
+func RebuildHistory(cfg Settings, manga string, chapters []DownloadedChapter) Settings {
+	if len(chapters) == 0 {
+		return cfg
+	}
+	index := -1
+	for i, title := range cfg.History.Titles {
+		if title.Title == manga {
+			index = i
+		}
+	}
+	if index < 0 {
+		cfg.History.Titles = append(cfg.History.Titles, Manga{Title: manga})
+		index = len(cfg.History.Titles) - 1
+	}
+	title := &cfg.History.Titles[index]
+	for _, record := range chapters {
+		title.Chapters = addChapter(title.Chapters, record)
+	}
+	last := title.Chapters[len(title.Chapters)-1]
+	if title.Provider == "" {
+		title.Provider = last.Provider
+	}
+	if title.Chapter == "" || title.Chapter.Less(last.Chapter.Next()) {
+		title.Chapter = last.Chapter.Next()
+	}
+	WriteSettings(cfg)
+	fmt.Println("History updated.")
+	return cfg
+}
+
+/*
+SearchChapters send the chapters downloaded for a manga, in reading order
+*/
+func SearchChapters(settings Settings, manga string) []DownloadedChapter {
+	for _, title := range settings.History.Titles {
+		if title.Title == manga {
+			return title.Chapters
+		}
+	}
+	return nil
+}
+
 /*
 DisplayHistory simply load the settings and display the titles, providers, download path and last
 dowloaded chapter, and highlight mangas that have available new chapters
 */
-func DisplayHistory(cfg *Settings) {
+func DisplayHistory(ctx context.Context, cfg *Settings) {
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Name", "Last chapter", "Provider"})
+	table.SetHeader([]string{"Name", "Last chapter", "Provider", "Downloaded"})
 	for _, title := range (*cfg).History.Titles {
-		chapter := fmt.Sprintf("%d", title.Chapter)
+		chapter := title.Chapter.String()
 		mangaTitle := title.Title
 		provider := title.Provider
-		if fetch.NextChapter(title.Provider, title.Title, title.Chapter) == true {
-			chapter = fmt.Sprintf("<%d>", title.Chapter)
+		adapter, err := fetch.GetProvider(title.Provider)
+		if err == nil && fetch.NextChapter(ctx, adapter, title.Title, title.Chapter) == true {
+			chapter = fmt.Sprintf("<%s>", title.Chapter)
 			mangaTitle = fmt.Sprintf("> %s", mangaTitle)
 			provider = fmt.Sprintf("[%s]", provider)
 		}
@@ -147,6 +566,7 @@ func DisplayHistory(cfg *Settings) {
 			mangaTitle,
 			chapter,
 			provider,
+			fmt.Sprintf("%d", len(title.Chapters)),
 		})
 	}
 	table.Render()
@@ -155,35 +575,30 @@ func DisplayHistory(cfg *Settings) {
 /*
 UpdateHistory register the last chapter downloaded for a manga, and the last provider used
 */
-func UpdateHistory(cfg Settings, manga string, chapter int, provider string) (newSettings Settings) {
-	if chapter < 0 {
-		chapter = 1
+func UpdateHistory(cfg Settings, manga string, chapter chapterid.ID, provider string) (newSettings Settings) {
+	if chapter == "" {
+		chapter = chapterid.FromInt(1)
 	}
 	if provider == "???" {
 		provider = cfg.Config.Provider
 	}
+	updated := Manga{Title: manga}
 	var titles []Manga
 	for _, title := range cfg.History.Titles {
 		if title.Title != manga {
-			titles = append(titles, Manga{
-				Title:    title.Title,
-				Chapter:  title.Chapter,
-				Provider: title.Provider,
-			})
+			titles = append(titles, title)
+		} else {
+			updated = title
 		}
 	}
-	titles = append(titles, Manga{
-		Title:    manga,
-		Chapter:  chapter,
-		Provider: provider,
-	})
+	updated.Chapter = chapter
+	updated.Provider = provider
+	titles = append(titles, updated)
 	newSettings = Settings{
-		Config{
-			OutputPath: cfg.Config.OutputPath,
-			Provider:   cfg.Config.Provider,
-		},
+		cfg.Config,
 		History{
-			Titles: titles,
+			Version: cfg.History.Version,
+			Titles:  titles,
 		},
 	}
 	WriteSettings(newSettings)